@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/luneo7/go-aws-azure-login/pkg/azurelogin"
+)
+
+func clearSession(profileName string, allProfiles bool) {
+	dir := azurelogin.Paths[azurelogin.CHROMIUM]
+	if !allProfiles {
+		profile := loadProfile(profileName)
+		dir = azurelogin.GetChromiumUserDataDir(profile.AzureTenantID, profile.AzureDefaultUsername)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		fmt.Fprintf(messages, "Fail to clear stored session: %v", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(messages, "Stored session removed from %s\n", dir)
+}
@@ -7,10 +7,16 @@ import (
 	"strconv"
 
 	"github.com/AlecAivazis/survey/v2"
+
+	"github.com/luneo7/go-aws-azure-login/pkg/azurelogin"
 )
 
 func configureProfile(profileName string) {
-	profile := getProfileConfig(profileName)
+	profile, err := configStore.GetProfileConfig(profileName)
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to load profile: %v", err)
+		os.Exit(1)
+	}
 
 	var qs = []*survey.Question{
 		{
@@ -45,6 +51,14 @@ func configureProfile(profileName string) {
 			Prompt:   &survey.Confirm{Message: "Stay logged in: skip authentication while refreshing aws credentials", Default: profile.AzureDefaultRememberMe},
 			Validate: survey.Required,
 		},
+		{
+			Name:   "useSecretStore",
+			Prompt: &survey.Confirm{Message: "Save passwords in the system keyring (or an encrypted file if unavailable) to use them with -no-prompt", Default: profile.AzureUseSecretStore},
+		},
+		{
+			Name:   "verifySaml",
+			Prompt: &survey.Confirm{Message: "Verify the signature and conditions of the SAML response with the federation metadata of the tenant", Default: profile.AzureVerifySAML},
+		},
 		{
 			Name:   "defaultRoleArn",
 			Prompt: &survey.Input{Message: "Default Role ARN (if multiple):", Default: profile.AzureDefaultRoleArn},
@@ -63,13 +77,51 @@ func configureProfile(profileName string) {
 		},
 	}
 
-	err := survey.Ask(qs, &profile)
-	if err != nil {
-		fmt.Printf("Fail to get profile answers: %v", err)
+	if err := survey.Ask(qs, &profile, surveyStdio()); err != nil {
+		fmt.Fprintf(messages, "Fail to get profile answers: %v", err)
 		os.Exit(1)
 	}
 
-	setProfileConfig(profileName, profile)
+	if err := configStore.SetProfileConfig(profileName, profile); err != nil {
+		fmt.Fprintf(messages, "Fail to save profile: %v", err)
+		os.Exit(1)
+	}
+
+	if profile.AzureUseSecretStore {
+		askUserForPassword(profileName, azurelogin.AZURE_PASSWORD_SECRET, "Azure Password (leave empty to keep the saved one):")
+		askUserForPassword(profileName, azurelogin.AZURE_TOTP_SECRET, "Azure Authenticator TOTP Secret, to generate verification codes (leave empty to keep the saved one):")
+
+		if profile.OktaDefaultUsername != nil {
+			askUserForPassword(profileName, azurelogin.OKTA_PASSWORD_SECRET, "Okta Password (leave empty to keep the saved one):")
+			askUserForPassword(profileName, azurelogin.OKTA_TOTP_SECRET, "Okta TOTP Secret, to generate verification codes (leave empty to keep the saved one):")
+		}
+	}
+}
+
+func askUserForPassword(profileName string, secretName string, message string) {
+	password := ""
+	prompt := &survey.Password{
+		Message: message,
+	}
+
+	if err := survey.AskOne(prompt, &password, surveyStdio()); err != nil {
+		fmt.Fprintf(messages, "Fail to get password: %v", err)
+		os.Exit(1)
+	}
+
+	if password == "" {
+		return
+	}
+
+	if err := openSecretStore(false).Set(azurelogin.GetSecretKey(profileName, secretName), password); err != nil {
+		fmt.Fprintf(messages, "Fail to save password: %v", err)
+		os.Exit(1)
+	}
+}
+
+// surveyStdio makes the survey prompts write to the messages.
+func surveyStdio() survey.AskOpt {
+	return survey.WithStdio(os.Stdin, messages, os.Stderr)
 }
 
 func stringPointerToString(p *string) string {
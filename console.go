@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod/lib/launcher"
+
+	"github.com/luneo7/go-aws-azure-login/pkg/azurelogin"
+)
+
+const (
+	AWS_FEDERATION_ENDPOINT     = "https://signin.aws.amazon.com/federation"
+	AWS_CN_FEDERATION_ENDPOINT  = "https://signin.amazonaws.cn/federation"
+	AWS_GOV_FEDERATION_ENDPOINT = "https://signin.amazonaws-us-gov.com/federation"
+	AWS_CONSOLE_URL             = "https://console.aws.amazon.com/"
+	AWS_CN_CONSOLE_URL          = "https://console.amazonaws.cn/"
+	AWS_GOV_CONSOLE_URL         = "https://console.amazonaws-us-gov.com/"
+
+	CONSOLE_ISSUER = "https://myapps.microsoft.com"
+)
+
+type federationSession struct {
+	SessionID    string `json:"sessionId"`
+	SessionKey   string `json:"sessionKey"`
+	SessionToken string `json:"sessionToken"`
+}
+
+type federationSigninToken struct {
+	SigninToken string
+}
+
+// openConsole prints the AWS Management Console sign-in URL for the credentials, opening it in the browser if asked to.
+func openConsole(credentials azurelogin.Credentials, region *string, destination string, openBrowser bool) {
+	consoleURL, err := getConsoleSigninURL(credentials, region, destination)
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to get console sign-in URL: %v", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintln(messages, consoleURL)
+
+	if openBrowser {
+		launcher.Open(consoleURL)
+	}
+}
+
+// getConsoleSigninURL exchanges the credentials for a sign-in token on the federation endpoint of the
+// region partition and returns the console URL signing in with it.
+func getConsoleSigninURL(credentials azurelogin.Credentials, region *string, destination string) (string, error) {
+	federationEndpoint := AWS_FEDERATION_ENDPOINT
+	consoleURL := AWS_CONSOLE_URL
+
+	switch azurelogin.GetAWSPartition(region) {
+	case azurelogin.AWS_GOV_PARTITION:
+		federationEndpoint = AWS_GOV_FEDERATION_ENDPOINT
+		consoleURL = AWS_GOV_CONSOLE_URL
+	case azurelogin.AWS_CN_PARTITION:
+		federationEndpoint = AWS_CN_FEDERATION_ENDPOINT
+		consoleURL = AWS_CN_CONSOLE_URL
+	}
+
+	session, err := json.Marshal(federationSession{
+		SessionID:    credentials.AwsAccessKeyID,
+		SessionKey:   credentials.AwsSecretAccessKey,
+		SessionToken: credentials.AwsSessionToken,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("Action", "getSigninToken")
+	query.Set("Session", string(session))
+
+	client := http.Client{Timeout: 30 * time.Second}
+
+	resp, err := client.Get(federationEndpoint + "?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("federation endpoint returned %s", resp.Status)
+	}
+
+	var token federationSigninToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(destination, "https://") {
+		consoleURL = destination
+	} else {
+		consoleURL += strings.TrimPrefix(destination, "/")
+	}
+
+	query = url.Values{}
+	query.Set("Action", "login")
+	query.Set("Issuer", CONSOLE_ISSUER)
+	query.Set("Destination", consoleURL)
+	query.Set("SigninToken", token.SigninToken)
+
+	return federationEndpoint + "?" + query.Encode(), nil
+}
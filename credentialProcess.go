@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/luneo7/go-aws-azure-login/pkg/azurelogin"
+)
+
+type credentialProcessOutput struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      string
+}
+
+// credentialProcess prints the credentials of the profile in the format expected by the
+// credential_process setting of the AWS CLI and SDKs, reusing the cached credentials while they are valid.
+func credentialProcess(
+	ctx context.Context,
+	profileName string,
+	forceRefresh bool,
+	awsNoVerifySsl bool,
+	noPrompt bool,
+	isGui bool,
+	disableLeakless bool,
+	fastPass bool,
+	diagnosticsDir string,
+	loginTimeout time.Duration) {
+
+	credentials := getCredentials(ctx, profileName, forceRefresh, awsNoVerifySsl, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
+
+	if err := json.NewEncoder(os.Stdout).Encode(newCredentialProcessOutput(credentials)); err != nil {
+		fmt.Fprintf(messages, "Fail to write credentials: %v", err)
+		os.Exit(1)
+	}
+}
+
+func newCredentialProcessOutput(credentials azurelogin.Credentials) credentialProcessOutput {
+	return credentialProcessOutput{
+		Version:         1,
+		AccessKeyId:     credentials.AwsAccessKeyID,
+		SecretAccessKey: credentials.AwsSecretAccessKey,
+		SessionToken:    credentials.AwsSessionToken,
+		Expiration:      credentials.AwsExpiration,
+	}
+}
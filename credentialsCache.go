@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/luneo7/go-aws-azure-login/pkg/azurelogin"
+)
+
+// getCredentials returns the cached credentials of the profile, logging in again when they are missing or about to expire.
+func getCredentials(
+	ctx context.Context,
+	profileName string,
+	forceRefresh bool,
+	awsNoVerifySsl bool,
+	noPrompt bool,
+	isGui bool,
+	disableLeakless bool,
+	fastPass bool,
+	diagnosticsDir string,
+	loginTimeout time.Duration) azurelogin.Credentials {
+
+	credentials, ok := loadCachedCredentials(profileName, noPrompt)
+
+	if !ok || forceRefresh {
+		credentials = login(ctx, profileName, awsNoVerifySsl, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
+		saveCachedCredentials(profileName, credentials, noPrompt)
+	}
+
+	return credentials
+}
+
+// openSecretStore opens the secret store, asking for the passphrase of the secrets file unless noPrompt is set.
+func openSecretStore(noPrompt bool) azurelogin.SecretStore {
+	if noPrompt {
+		return azurelogin.OpenSecretStore(nil)
+	}
+	return azurelogin.OpenSecretStore(azurelogin.SurveyPrompter{Output: messages})
+}
+
+// getCachedCredentialsPath returns the path of the cached credentials of the profile, escaping the path
+// separators of its name so that it stays in the cache directory.
+func getCachedCredentialsPath(profileName string) string {
+	return filepath.Join(azurelogin.Paths[azurelogin.CACHE], url.PathEscape(profileName)+".json")
+}
+
+// loadCachedCredentials returns the cached credentials of the profile, if they exist and are not about to expire.
+func loadCachedCredentials(profileName string, noPrompt bool) (azurelogin.Credentials, bool) {
+	var output credentialProcessOutput
+
+	data, err := readCachedCredentials(profileName, noPrompt)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(messages, "Fail to read cached credentials: %v\n", err)
+		}
+		return azurelogin.Credentials{}, false
+	}
+
+	if err := json.Unmarshal(data, &output); err != nil {
+		return azurelogin.Credentials{}, false
+	}
+
+	expirationDate, err := time.Parse(azurelogin.TimeFormat, output.Expiration)
+	if err != nil || azurelogin.IsAboutToExpire(expirationDate) {
+		return azurelogin.Credentials{}, false
+	}
+
+	return azurelogin.Credentials{
+		AwsAccessKeyID:     output.AccessKeyId,
+		AwsSecretAccessKey: output.SecretAccessKey,
+		AwsSessionToken:    output.SessionToken,
+		AwsExpiration:      output.Expiration,
+	}, true
+}
+
+func saveCachedCredentials(profileName string, credentials azurelogin.Credentials, noPrompt bool) {
+	data, err := json.Marshal(newCredentialProcessOutput(credentials))
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to encode cached credentials: %v\n", err)
+		return
+	}
+
+	if err := writeCachedCredentials(profileName, data, noPrompt); err != nil {
+		fmt.Fprintf(messages, "Fail to write cached credentials: %v\n", err)
+	}
+}
+
+// readCachedCredentials reads the cached credentials from the secret store when the profile
+// uses it, and from the cache directory otherwise.
+func readCachedCredentials(profileName string, noPrompt bool) ([]byte, error) {
+	if loadProfile(profileName).AzureUseSecretStore {
+		data, err := openSecretStore(noPrompt).Get(azurelogin.GetSecretKey(profileName, azurelogin.CREDENTIALS_SECRET))
+		return []byte(data), err
+	}
+
+	return os.ReadFile(getCachedCredentialsPath(profileName))
+}
+
+func writeCachedCredentials(profileName string, data []byte, noPrompt bool) error {
+	if loadProfile(profileName).AzureUseSecretStore {
+		return openSecretStore(noPrompt).Set(azurelogin.GetSecretKey(profileName, azurelogin.CREDENTIALS_SECRET), string(data))
+	}
+
+	if err := os.MkdirAll(azurelogin.Paths[azurelogin.CACHE], 0700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(getCachedCredentialsPath(profileName), data, 0600)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// execCommand runs the command with the credentials of the profile injected in its environment,
+// forwarding the received signals to it and exiting with its exit code, or 128 + the signal number
+// when it was killed by a signal.
+func execCommand(
+	ctx context.Context,
+	profileName string,
+	args []string,
+	forceRefresh bool,
+	awsNoVerifySsl bool,
+	noPrompt bool,
+	isGui bool,
+	disableLeakless bool,
+	fastPass bool,
+	diagnosticsDir string,
+	loginTimeout time.Duration) {
+
+	credentials := getCredentials(ctx, profileName, forceRefresh, awsNoVerifySsl, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
+	profile := loadProfile(profileName)
+
+	env := getCredentialsEnv(credentials, profile.Region)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(filterEnv(os.Environ(), env), env...)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(messages, "Fail to run command: %v", err)
+		os.Exit(1)
+	}
+
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// like the shells, exit with 128 + the signal number when the command was killed by a signal
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				os.Exit(128 + int(status.Signal()))
+			}
+			if exitErr.ExitCode() > 0 {
+				os.Exit(exitErr.ExitCode())
+			}
+		}
+		fmt.Fprintf(messages, "Fail to run command: %v", err)
+		os.Exit(1)
+	}
+}
+
+// filterEnv removes from the environment the variables that are overridden or that
+// would make the AWS CLI and SDKs pick other credentials than the injected ones.
+func filterEnv(environ []string, overrides []string) []string {
+	removed := map[string]bool{
+		"AWS_PROFILE":         true,
+		"AWS_DEFAULT_PROFILE": true,
+		"AWS_SECURITY_TOKEN":  true,
+	}
+
+	for _, kv := range overrides {
+		removed[strings.SplitN(kv, "=", 2)[0]] = true
+	}
+
+	var filtered []string
+
+	for _, kv := range environ {
+		if !removed[strings.SplitN(kv, "=", 2)[0]] {
+			filtered = append(filtered, kv)
+		}
+	}
+
+	return filtered
+}
@@ -1,870 +1,423 @@
 package main
 
 import (
-	"bytes"
-	"compress/flate"
 	"context"
-	"encoding/base64"
-	"encoding/xml"
 	"errors"
 	"fmt"
-	"net/url"
 	"os"
-	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/AlecAivazis/survey/v2"
-	"github.com/go-rod/rod"
-	"github.com/go-rod/rod/lib/launcher"
-	"github.com/go-rod/rod/lib/proto"
-	"github.com/google/uuid"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/luneo7/go-aws-azure-login/pkg/azurelogin"
 )
 
-const (
-	AZURE_AD_SSO          = "autologon.microsoftazuread-sso.com"
-	AWS_SAML_ENDPOINT     = "https://signin.aws.amazon.com/saml"
-	AWS_CN_SAML_ENDPOINT  = "https://signin.amazonaws.cn/saml"
-	AWS_GOV_SAML_ENDPOINT = "https://signin.amazonaws-us-gov.com/saml"
-	OKTA_SELECT_FAST_PASS = "OKTA SELECT FastPass"
-	OKTA_SELECT_PUSH_FORM = "OKTA SELECT PUSH Form"
-	OKTA_DO_PUSH_FORM     = "OKTA DO PUSH Form"
-
-	WIDTH  = 425
-	HEIGHT = 550
-)
-
-type state struct {
-	name     string
-	selector string
-	handler  func(pg *rod.Page, el *rod.Element, noPrompt bool, defaultUserName string, defaultUserPassword *string, defaultOktaUserName *string, defaultOktaPassword *string, isGui bool)
-}
-
-type samlResponse struct {
-	XMLName   xml.Name
-	Assertion samlAssertion `xml:"Assertion"`
-}
-
-type samlAssertion struct {
-	XMLName            xml.Name
-	AttributeStatement samlAttributeStatement
-}
+var configStore = azurelogin.NewConfigStore()
 
-type samlAttributeValue struct {
-	XMLName xml.Name
-	Type    string `xml:"xsi:type,attr"`
-	Value   string `xml:",innerxml"`
+type assumeRoleJob struct {
+	profileName   string
+	saml          string
+	role          azurelogin.Role
+	durationHours int32
+	opts          azurelogin.Options
 }
 
-type samlAttribute struct {
-	XMLName         xml.Name
-	Name            string               `xml:",attr"`
-	AttributeValues []samlAttributeValue `xml:"AttributeValue"`
+type assumeRoleResult struct {
+	profileName string
+	credentials azurelogin.Credentials
+	err         error
 }
 
-type samlAttributeStatement struct {
-	XMLName    xml.Name
-	Attributes []samlAttribute `xml:"Attribute"`
-}
+// loadProfile returns the configuration of the profile, overridden by the environment variables.
+func loadProfile(profileName string) azurelogin.ProfileConfig {
+	profile, err := configStore.LoadProfile(profileName)
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to load profile: %v", err)
+		os.Exit(1)
+	}
 
-type role struct {
-	roleArn      string
-	principalArn string
+	return profile
 }
 
-var states = []state{
-	{
-		name:     "username input",
-		selector: `input[name="loginfmt"]:not(.moveOffScreen)`,
-		handler: func(pg *rod.Page, el *rod.Element, noPrompt bool, defaultUserName string, _ *string, _ *string, _ *string, isGui bool) {
-			username := defaultUserName
-
-			if !noPrompt && !isGui {
-				prompt := &survey.Input{
-					Message: "Azure Username:",
-					Default: defaultUserName,
-				}
-				survey.AskOne(prompt, &username, survey.WithValidator(survey.Required))
-			}
-
-			if len(username) > 0 {
-				el.MustWaitVisible()
-				el.MustSelectAllText().MustInput("")
-				el.MustInput(strings.TrimSpace(username))
-
-				sb := pg.MustElement(`input[type=submit]`)
-
-				sb.MustWaitVisible()
-				wait := pg.MustWaitRequestIdle()
-				sb.MustClick()
-				wait()
-
-				pContext := pg.GetContext()
-				defer func() {
-					pg.Context(pContext)
-				}()
-
-				ctx, cancel := context.WithCancel(pContext)
-				defer cancel()
-
-				ch := make(chan bool, 1)
-
-				go func() {
-					for {
-						select {
-						case <-ctx.Done():
-							return
-						default:
-							_, err := pg.Sleeper(rod.NotFoundSleeper).Element("input[name=loginfmt]")
-							if err != nil {
-								ch <- true
-								return
-							}
-						}
-					}
-				}()
-
-				go func() {
-					pg.Timeout(20 * time.Second).Race().
-						Element("input[name=loginfmt].has-error").
-						Element("input[name=loginfmt].moveOffScreen").
-						Element("input[name=loginfmt]").Handle(func(e *rod.Element) error {
-						return e.WaitInvisible()
-					}).Do()
-
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						ch <- true
-						return
-					}
-				}()
-
-				select {
-				case <-ch:
-				case <-time.After(25 * time.Second):
-				}
-			}
-		},
-	},
-	{
-		name:     "password input",
-		selector: `input[name="Password"]:not(.moveOffScreen),input[name="passwd"]:not(.moveOffScreen)`,
-		handler: func(pg *rod.Page, el *rod.Element, noPrompt bool, _ string, defaultUserPassword *string, _ *string, _ *string, isGui bool) {
-			alert, err := pg.Sleeper(rod.NotFoundSleeper).Element(".alert-error")
-
-			if alert != nil && err == nil {
-				fmt.Println(alert.Text())
-			}
-
-			var password string = ""
-
-			if noPrompt && defaultUserPassword != nil {
-				password = *defaultUserPassword
-			} else if !isGui {
-				prompt := &survey.Password{
-					Message: "Azure Password",
-				}
-				survey.AskOne(prompt, &password, survey.WithValidator(survey.Required))
-			}
-
-			if len(password) > 0 {
-				el.MustWaitVisible()
-				el.MustSelectAllText().MustInput("")
-				el.MustInput(password)
-
-				wait := pg.MustWaitRequestIdle()
-				pg.MustElement("span[class=submit],input[type=submit]").MustClick()
-				wait()
-
-				time.Sleep(time.Millisecond * 500)
-			}
-		},
-	},
-	{
-		name:     "OKTA username input",
-		selector: `form:not(.o-form-saving) > div span.okta-form-input-field input[name="identifier"]:not([disabled])`,
-		handler: func(pg *rod.Page, el *rod.Element, noPrompt bool, defaultUserName string, defaultUserPassword *string, defaultOktaUserName *string, defaultOktaPassword *string, isGui bool) {
-			errorSelector := `div.o-form-error-container`
-			errorContainer, err := pg.Sleeper(rod.NotFoundSleeper).Element(errorSelector)
-
-			if errorContainer != nil && err == nil {
-				t, _ := errorContainer.Text()
-				if t != "" {
-					fmt.Println(t)
-				}
-			}
-
-			infoSelector := `div.o-form-info-container`
-			infoContainer, err := pg.Sleeper(rod.NotFoundSleeper).Element(infoSelector)
-			if infoContainer != nil && err == nil {
-				t, _ := infoContainer.Text()
-				if t != "" {
-					fmt.Println(t)
-				}
-			}
-
-			var username string = ""
-
-			if noPrompt {
-				if defaultOktaUserName != nil {
-					username = *defaultOktaUserName
-				} else {
-					username = defaultUserName
-				}
-			} else if !isGui {
-				defUser := defaultUserName
-				if defaultOktaUserName != nil {
-					defUser = *defaultOktaUserName
-				}
-				promptUsername := &survey.Input{
-					Message: "Okta Username:",
-					Default: defUser,
-				}
-				survey.AskOne(promptUsername, &username, survey.WithValidator(survey.Required))
-			}
-
-			if len(username) > 0 {
-
-				el.MustWaitVisible()
-				el.MustSelectAllText().MustInput("")
-				el.MustInput(username)
-
-				inputSelector := `form:not(.o-form-saving) > div span.okta-form-input-field input[name="identifier"]:not([disabled])`
-
-				btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`input:not([disabled]):not(.link-button-disabled):not(.btn-disabled)[type=submit]`)
-				if err == nil {
-					wait := pg.MustWaitRequestIdle()
-					btn.MustClick()
-					wait()
-
-					pContext := pg.GetContext()
-					defer func() {
-						pg.Context(pContext)
-					}()
-
-					ctx, cancel := context.WithCancel(pContext)
-					defer cancel()
-
-					ch := make(chan bool, 1)
-
-					go func() {
-						for {
-							select {
-							case <-ctx.Done():
-								return
-							default:
-								_, err := pg.Sleeper(rod.NotFoundSleeper).Element(inputSelector)
-								if err != nil {
-									ch <- true
-									return
-								}
-							}
-						}
-					}()
-
-					go func() {
-						pg.Timeout(20 * time.Second).Race().
-							Element(errorSelector + `.o-form-has-errors`).Handle(func(e *rod.Element) error {
-							if e != nil {
-								t, _ := e.Text()
-								if t != "" {
-									return errors.New("error returned")
-								}
-							}
-							return nil
-						}).
-							Element(inputSelector).Handle(func(e *rod.Element) error {
-							return e.WaitInvisible()
-						}).Do()
-
-						select {
-						case <-ctx.Done():
-							return
-						default:
-							ch <- true
-							return
-						}
-					}()
-
-					select {
-					case <-ch:
-					case <-time.After(25 * time.Second):
-					}
-				}
-			}
-		},
-	},
-	{
-		name:     "OKTA password input",
-		selector: `form:not(.o-form-saving) > div span.okta-form-input-field input[type="password"]:not([disabled])`,
-		handler: func(pg *rod.Page, el *rod.Element, noPrompt bool, defaultUserName string, defaultUserPassword *string, defaultOktaUserName *string, defaultOktaPassword *string, isGui bool) {
-			errorSelector := `div.o-form-error-container`
-			errorContainer, err := pg.Sleeper(rod.NotFoundSleeper).Element(errorSelector)
-
-			if errorContainer != nil && err == nil {
-				t, _ := errorContainer.Text()
-				if t != "" {
-					fmt.Println(t)
-				}
-			}
-
-			infoSelector := `div.o-form-info-container`
-			infoContainer, err := pg.Sleeper(rod.NotFoundSleeper).Element(infoSelector)
-			if infoContainer != nil && err == nil {
-				t, _ := infoContainer.Text()
-				if t != "" {
-					fmt.Println(t)
-				}
-			}
-
-			var password string = ""
-			shouldAskPassword := true
-
-			if noPrompt {
-				if defaultOktaPassword != nil {
-					password = *defaultOktaPassword
-					shouldAskPassword = false
-				} else if defaultUserPassword != nil {
-					password = *defaultUserPassword
-					shouldAskPassword = false
-				}
-			}
-
-			if shouldAskPassword && !isGui {
-				promptPasswd := &survey.Password{
-					Message: "Okta Password:",
-				}
-				survey.AskOne(promptPasswd, &password, survey.WithValidator(survey.Required))
-			}
-
-			if len(password) > 0 {
-
-				time.Sleep(time.Millisecond * 500)
-
-				el.MustWaitVisible()
-				el.MustSelectAllText().MustInput("")
-				el.MustInput(password)
-
-				inputSelector := `form:not(.o-form-saving) > div span.okta-form-input-field input[type="password"]:not([disabled])`
-
-				btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`input:not([disabled]):not(.link-button-disabled):not(.btn-disabled)[type=submit]`)
-				if err == nil {
-					wait := pg.MustWaitRequestIdle()
-					btn.MustClick()
-					wait()
-
-					pContext := pg.GetContext()
-					defer func() {
-						pg.Context(pContext)
-					}()
-
-					ctx, cancel := context.WithCancel(pContext)
-					defer cancel()
-
-					ch := make(chan bool, 1)
-
-					go func() {
-						for {
-							select {
-							case <-ctx.Done():
-								return
-							default:
-								_, err := pg.Sleeper(rod.NotFoundSleeper).Element(inputSelector)
-								if err != nil {
-									ch <- true
-									return
-								}
-							}
-						}
-					}()
-
-					go func() {
-						pg.Timeout(20 * time.Second).Race().
-							Element(errorSelector + `.o-form-has-errors`).Handle(func(e *rod.Element) error {
-							if e != nil {
-								t, _ := e.Text()
-								if t != "" {
-									return errors.New("error returned")
-								}
-							}
-							return nil
-						}).
-							Element(inputSelector).Handle(func(e *rod.Element) error {
-							return e.WaitInvisible()
-						}).Do()
-
-						select {
-						case <-ctx.Done():
-							return
-						default:
-							ch <- true
-							return
-						}
-					}()
-
-					select {
-					case <-ch:
-					case <-time.After(25 * time.Second):
-					}
-				}
-			}
-		},
-	},
-	{
-		name:     OKTA_SELECT_FAST_PASS,
-		selector: `div[data-se="okta_verify-signed_nonce"] > a:not([disabled]):not(.link-button-disabled):not(.btn-disabled)`,
-		handler: func(pg *rod.Page, el *rod.Element, _ bool, defaultUserName string, _ *string, _ *string, _ *string, _ bool) {
-			alert, err := pg.Sleeper(rod.NotFoundSleeper).Element(".infobox-error")
-
-			if alert != nil && err == nil {
-				t, _ := alert.Text()
-				if t != "" {
-					fmt.Println(t)
-				}
-			}
-
-			btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`div[data-se="okta_verify-signed_nonce"] > a:not([disabled]):not(.btn-disabled):not(.link-button-disabled)`)
-			if err == nil && btn != nil {
-				btn.MustWaitVisible()
-				wait := pg.MustWaitRequestIdle()
-				btn.MustClick()
-				wait()
-				time.Sleep(time.Millisecond * 500)
-			}
-		},
-	},
-	{
-		name:     OKTA_SELECT_PUSH_FORM,
-		selector: `div[data-se="okta_verify-push"] > a:not([disabled]):not(.link-button-disabled):not(.btn-disabled)`,
-		handler: func(pg *rod.Page, el *rod.Element, _ bool, defaultUserName string, _ *string, _ *string, _ *string, _ bool) {
-			alert, err := pg.Sleeper(rod.NotFoundSleeper).Element(".infobox-error")
-
-			if alert != nil && err == nil {
-				t, _ := alert.Text()
-				if t != "" {
-					fmt.Println(t)
-				}
-			}
-
-			btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`div[data-se="okta_verify-push"] > a:not([disabled]):not(.btn-disabled):not(.link-button-disabled)`)
-			if err == nil && btn != nil {
-				btn.MustWaitVisible()
-				wait := pg.MustWaitRequestIdle()
-				btn.MustClick()
-				wait()
-				time.Sleep(time.Millisecond * 500)
-			}
-		},
-	},
-	{
-		name:     OKTA_DO_PUSH_FORM,
-		selector: `a.send-push:not([disabled]):not(.link-button-disabled):not(.btn-disabled)`,
-		handler: func(pg *rod.Page, el *rod.Element, _ bool, defaultUserName string, _ *string, _ *string, _ *string, _ bool) {
-			alert, err := pg.Sleeper(rod.NotFoundSleeper).Element(".infobox-error")
-
-			if alert != nil && err == nil {
-				t, _ := alert.Text()
-				if t != "" {
-					fmt.Println(t)
-				}
-			}
+func isProfileAboutToExpire(profileName string) bool {
+	aboutToExpire, err := configStore.IsProfileAboutToExpire(profileName)
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to check profile expiration: %v", err)
+		os.Exit(1)
+	}
 
-			btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`a.send-push:not([disabled]):not(.btn-disabled):not(.link-button-disabled)`)
-			if err == nil && btn != nil {
-				btn.MustWaitVisible()
-				wait := pg.MustWaitRequestIdle()
-				btn.MustClick()
-				wait()
-				time.Sleep(time.Millisecond * 500)
-			}
-		},
-	},
+	return aboutToExpire
 }
 
-func loadProfileFromEnv() profileConfig {
-	envVars := []string{
-		"azure_tenant_id",
-		"azure_app_id_uri",
-		"azure_default_username",
-		"azure_default_password",
-		"azure_default_role_arn",
-		"azure_default_duration_hours",
-		"region",
-		"okta_default_username",
-		"okta_default_password",
-	}
-
-	profile := profileConfig{}
-
-	v := reflect.ValueOf(&profile).Elem()
-	t := v.Type()
-
-	for _, envVar := range envVars {
-		val, exists := os.LookupEnv(envVar)
-		if exists {
-			for i := 0; i < v.NumField(); i++ {
-				tag := t.Field(i).Tag.Get(tagName)
-				if tag == envVar {
-					f := v.Field(i)
-					if f.Kind() == reflect.String {
-						f.SetString(val)
-					} else if f.Kind() == reflect.Ptr {
-						f.Set(reflect.ValueOf(&val))
-					}
-				}
-			}
-		}
+func newLoginOptions(
+	profileName string,
+	profile azurelogin.ProfileConfig,
+	awsNoVerifySsl bool,
+	noPrompt bool,
+	isGui bool,
+	disableLeakless bool,
+	fastPass bool,
+	diagnosticsDir string,
+	loginTimeout time.Duration) azurelogin.Options {
+
+	return azurelogin.Options{
+		ProfileName:     profileName,
+		Profile:         profile,
+		NoPrompt:        noPrompt,
+		Gui:             isGui,
+		DisableLeakless: disableLeakless,
+		FastPass:        fastPass,
+		NoVerifySSL:     awsNoVerifySsl,
+		DiagnosticsDir:  diagnosticsDir,
+		LoginTimeout:    loginTimeout,
+		Output:          messages,
 	}
-
-	return profile
 }
 
-func loadProfile(profileName string) profileConfig {
-	profile := getProfileConfig(profileName)
-	envProfile := loadProfileFromEnv()
-
-	if (envProfile != profileConfig{}) {
-		v := reflect.ValueOf(&profile).Elem()
-		vEnv := reflect.ValueOf(&envProfile).Elem()
-		t := v.Type()
+func login(
+	ctx context.Context,
+	profileName string,
+	awsNoVerifySsl bool,
+	noPrompt bool,
+	isGui bool,
+	disableLeakless bool,
+	fastPass bool,
+	diagnosticsDir string,
+	loginTimeout time.Duration) azurelogin.Credentials {
 
-		for i := 0; i < t.NumField(); i++ {
-			value := v.Field(i)
-			envValue := vEnv.Field(i)
+	profile := loadProfile(profileName)
 
-			if envValue.Kind() == reflect.Ptr {
-				if !envValue.IsNil() {
-					value.Set(reflect.ValueOf(envValue.Interface()))
-				}
-			} else if envValue.Kind() == reflect.Bool {
-				if value.Interface().(bool) != envValue.Interface().(bool) {
-					value.SetBool(envValue.Interface().(bool))
-				}
-			} else {
-				nValue := envValue.Interface().(string)
-				if value.Interface().(string) != nValue && nValue != "" {
-					value.SetString(envValue.Interface().(string))
-				}
-			}
-		}
+	credentials, err := azurelogin.Login(ctx, newLoginOptions(profileName, profile, awsNoVerifySsl, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout))
+	if err != nil {
+		exitWithLoginError(err)
 	}
 
-	return profile
+	return *credentials
 }
 
-func login(
+// loginMultipleRoles logs in once and assumes every selected role of the SAML response,
+// writing the credentials of each role to its own profile.
+func loginMultipleRoles(
+	ctx context.Context,
 	profileName string,
 	awsNoVerifySsl bool,
 	noPrompt bool,
 	isGui bool,
 	disableLeakless bool,
-	fastPass bool) {
+	fastPass bool,
+	diagnosticsDir string,
+	loginTimeout time.Duration) {
 
 	profile := loadProfile(profileName)
 
-	assertionConsumerServiceURL := AWS_SAML_ENDPOINT
+	opts := newLoginOptions(profileName, profile, awsNoVerifySsl, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
 
-	if profile.Region != nil {
-		if strings.HasPrefix(*profile.Region, "us-gov") {
-			assertionConsumerServiceURL = AWS_GOV_SAML_ENDPOINT
-		} else if strings.HasPrefix(*profile.Region, "cn-") {
-			assertionConsumerServiceURL = AWS_CN_SAML_ENDPOINT
-		}
+	saml, response, err := azurelogin.GetSAMLResponse(ctx, opts)
+	if err != nil {
+		exitWithLoginError(err)
 	}
 
-	loginUrl := createLoginUrl(profile.AzureAppIDUri, profile.AzureTenantID, assertionConsumerServiceURL)
+	roles, err := response.Roles()
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to parse roles: %v", err)
+		os.Exit(1)
+	}
 
-	saml := performLogin(loginUrl, noPrompt, profile.AzureDefaultUsername, profile.AzureDefaultPassword, profile.OktaDefaultUsername, profile.OktaDefaultPassword, isGui, disableLeakless, fastPass)
+	session, err := response.SessionAttributes()
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to parse session attributes: %v", err)
+		os.Exit(1)
+	}
 
-	roles := parseRolesFromSamlResponse(saml)
+	roleProfiles := askUserForRolesAndProfiles(roles, noPrompt, parseRoleProfiles(profile.AzureRoleProfiles))
 
-	rl, durationHours := askUserForRoleAndDuration(roles, noPrompt, profile.AzureDefaultRoleArn, profile.AzureDefaultDurationHours)
+	durationHours, err := azurelogin.AskUserForDuration(session, opts)
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to get session duration: %v", err)
+		os.Exit(1)
+	}
 
-	assumeRole(profileName, saml, rl, durationHours, awsNoVerifySsl, profile.Region)
-}
+	for _, rl := range roles {
+		targetProfileName, ok := roleProfiles[rl.RoleArn]
+		if !ok {
+			continue
+		}
 
-func loginAll(forceRefresh bool, awsNoVerifySsl bool, noPrompt bool, isGui bool, disableLeakless bool, fastPass bool) {
-	allProfiles := getAllProfileNames()
+		credentials, err := azurelogin.AssumeRole(ctx, saml, rl, durationHours, opts)
+		if err != nil {
+			fmt.Fprintf(messages, "Fail to assume role %s: %v", rl.RoleArn, err)
+			os.Exit(azurelogin.ExitCode(err))
+		}
 
-	for _, profileName := range allProfiles {
-		if !forceRefresh && !isProfileAboutToExpire(profileName) {
-			continue
+		if err := configStore.SetProfileCredentials(targetProfileName, *credentials); err != nil {
+			fmt.Fprintf(messages, "Fail to save credentials: %v", err)
+			os.Exit(1)
 		}
 
-		login(profileName, awsNoVerifySsl, noPrompt, isGui, disableLeakless, fastPass)
+		fmt.Fprintf(messages, "Assumed role %s in profile %s\n", rl.RoleArn, targetProfileName)
 	}
 }
 
-func createLoginUrl(appIDUri string, tenantID string, assertionConsumerServiceURL string) string {
-	id := uuid.NewString()
+// loginAll refreshes the credentials of all profiles, logging in once for each group of profiles sharing
+// the same Azure authority, tenant, application, AWS SAML endpoint, default usernames and SAML verification
+// settings, and reusing the SAML response while it is valid. The roles are assumed by up to concurrency workers
+// while the next groups log in, and a single writer saves their credentials. It exits with a non-zero code
+// if any profile failed to refresh.
+func loginAll(ctx context.Context, forceRefresh bool, awsNoVerifySsl bool, noPrompt bool, isGui bool, disableLeakless bool, fastPass bool, diagnosticsDir string, loginTimeout time.Duration, concurrency int) {
+	allProfiles, err := configStore.GetAllProfileNames()
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to load profiles: %v", err)
+		os.Exit(1)
+	}
 
-	samlRequest := fmt.Sprintf(`
-	<samlp:AuthnRequest xmlns="urn:oasis:names:tc:SAML:2.0:metadata" ID="id%s" Version="2.0" IssueInstant="%s" IsPassive="false" AssertionConsumerServiceURL="%s" xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">
-		<Issuer xmlns="urn:oasis:names:tc:SAML:2.0:assertion">%s</Issuer>
-		<samlp:NameIDPolicy Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"></samlp:NameIDPolicy>
-	</samlp:AuthnRequest>
-	`, id, time.Now().Format(time.RFC3339), assertionConsumerServiceURL, appIDUri)
+	var groupKeys []string
+	groups := map[string][]string{}
+	profiles := map[string]azurelogin.ProfileConfig{}
 
-	var buffer bytes.Buffer
+	for _, profileName := range allProfiles {
+		if !forceRefresh && !isProfileAboutToExpire(profileName) {
+			continue
+		}
 
-	flateWriter, _ := flate.NewWriter(&buffer, -1)
+		profile := loadProfile(profileName)
+		if profile.AzureTenantID == "" || profile.AzureAppIDUri == "" {
+			fmt.Fprintf(messages, "Skipping profile %s: not configured\n", profileName)
+			continue
+		}
 
-	flateWriter.Write([]byte(samlRequest))
-	flateWriter.Flush()
-	flateWriter.Close()
+		// the SAML response is only shared by profiles of the same user verifying it the same way
+		key := strings.Join([]string{
+			profile.AuthorityURL(),
+			profile.AzureTenantID,
+			profile.AzureAppIDUri,
+			profile.AssertionConsumerServiceURL(),
+			profile.AzureDefaultUsername,
+			stringPointerToString(profile.OktaDefaultUsername),
+			strconv.FormatBool(profile.AzureVerifySAML),
+			stringPointerToString(profile.AzureSAMLCertificate),
+			stringPointerToString(profile.AzureFederationMetadataURL),
+		}, "|")
+		if _, ok := groups[key]; !ok {
+			groupKeys = append(groupKeys, key)
+		}
 
-	samlBase64 := base64.StdEncoding.EncodeToString(buffer.Bytes())
+		groups[key] = append(groups[key], profileName)
+		profiles[profileName] = profile
+	}
 
-	return fmt.Sprintf("https://login.microsoftonline.com/%s/saml2?SAMLRequest=%s", tenantID, url.QueryEscape(samlBase64))
-}
+	if concurrency < 1 {
+		concurrency = 1
+	}
 
-func performLogin(urlString string, noPrompt bool, defaultUserName string, defaultUserPassword *string, defaultOktaUserName *string, defaultOktaPassword *string, isGui bool, disableLeakless bool, fastpass bool) string {
-	l := launcher.New().Headless(!isGui)
+	jobs := make(chan assumeRoleJob)
+	results := make(chan assumeRoleResult)
 
-	l.Leakless(!disableLeakless)
+	var wg sync.WaitGroup
 
-	u := l.MustLaunch()
-	browser := rod.New().ControlURL(u).MustConnect()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				credentials, err := azurelogin.AssumeRole(ctx, job.saml, job.role, job.durationHours, job.opts)
+				result := assumeRoleResult{profileName: job.profileName, err: err}
+				if err == nil {
+					result.credentials = *credentials
+				}
+				results <- result
+			}
+		}()
+	}
 
-	defer browser.MustClose()
+	var refreshed []string
+	var failed []assumeRoleResult
+	done := make(chan struct{})
 
-	router := browser.HijackRequests()
-	defer router.MustStop()
+	go func() {
+		defer close(done)
+		for result := range results {
+			if result.err == nil {
+				result.err = configStore.SetProfileCredentials(result.profileName, result.credentials)
+			}
 
-	samlResponseChan := make(chan string, 1)
-	samlResponse := ""
+			if result.err != nil {
+				failed = append(failed, result)
+				continue
+			}
 
-	router.MustAdd("https://*amazon*", func(ctx *rod.Hijack) {
-		reqURL := ctx.Request.URL().String()
+			refreshed = append(refreshed, result.profileName)
+		}
+	}()
 
-		if reqURL == AWS_SAML_ENDPOINT || reqURL == AWS_GOV_SAML_ENDPOINT || reqURL == AWS_CN_SAML_ENDPOINT {
+	for _, key := range groupKeys {
+		saml := ""
+		var response *azurelogin.SAMLResponse
+		var loginErr error
 
-			val, err := url.ParseQuery(ctx.Request.Body())
+		for _, profileName := range groups[key] {
+			profile := profiles[profileName]
 
-			if err != nil {
-				fmt.Printf("Fail to saml endpoint response: %v", err)
-				os.Exit(1)
+			if loginErr == nil && ctx.Err() != nil {
+				loginErr = azurelogin.NewInterruptedLoginError(ctx, nil)
 			}
 
-			samlResponseChan <- val.Get("SAMLResponse")
+			opts := newLoginOptions(profileName, profile, awsNoVerifySsl, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
 
-			ctx.Response.Fail(proto.NetworkErrorReasonInternetDisconnected)
-		} else {
-			ctx.ContinueRequest(&proto.FetchContinueRequest{})
-		}
-	})
-
-	router.MustAdd("https://*okta*", func(ctx *rod.Hijack) {
-		reqURL, error := url.Parse(ctx.Request.URL().String())
-		if error == nil {
-			values := reqURL.Query()
-			if values.Has("username") {
-				values.Del("username")
-				reqURL.RawQuery = values.Encode()
-
-				ctx.ContinueRequest(&proto.FetchContinueRequest{URL: reqURL.String()})
-				return
+			if loginErr == nil && (response == nil || !response.IsValid()) {
+				saml, response, loginErr = azurelogin.GetSAMLResponse(ctx, opts)
 			}
-		}
-		ctx.ContinueRequest(&proto.FetchContinueRequest{})
-	})
-
-	go router.Run()
 
-	page := browser.MustPage()
-	wait := page.WaitNavigation(proto.PageLifecycleEventNameDOMContentLoaded)
-	page.MustNavigate(urlString)
-	wait()
-
-	if isGui && !noPrompt {
-		r, ok := <-samlResponseChan
-		if ok {
-			samlResponse = r
-		}
-	} else {
-	Loop:
-		for {
-			for _, st := range states {
-				select {
-				case r, ok := <-samlResponseChan:
-					if ok {
-						samlResponse = r
-						break Loop
-					}
-				default:
-				}
+			if loginErr != nil {
+				results <- assumeRoleResult{profileName: profileName, err: loginErr}
+				continue
+			}
 
-				if (fastpass && (st.name == OKTA_SELECT_PUSH_FORM || st.name == OKTA_DO_PUSH_FORM)) || (!fastpass && st.name == OKTA_SELECT_FAST_PASS) {
-					continue
-				}
+			roles, err := response.Roles()
+			if err != nil {
+				results <- assumeRoleResult{profileName: profileName, err: fmt.Errorf("fail to parse roles: %w", err)}
+				continue
+			}
 
-				el, err := page.Sleeper(rod.NotFoundSleeper).Element(st.selector)
+			session, err := response.SessionAttributes()
+			if err != nil {
+				results <- assumeRoleResult{profileName: profileName, err: fmt.Errorf("fail to parse session attributes: %w", err)}
+				continue
+			}
 
-				if err == nil {
-					st.handler(page, el, noPrompt, defaultUserName, defaultUserPassword, defaultOktaUserName, defaultOktaPassword, isGui)
-				}
+			rl, durationHours, err := azurelogin.AskUserForRoleAndDuration(roles, session, opts)
+			if err != nil {
+				results <- assumeRoleResult{profileName: profileName, err: err}
+				continue
 			}
+
+			jobs <- assumeRoleJob{profileName: profileName, saml: saml, role: rl, durationHours: durationHours, opts: opts}
 		}
 	}
 
-	return samlResponse
-}
-
-func parseRolesFromSamlResponse(assertion string) []role {
-	b64, err := base64.StdEncoding.DecodeString(assertion)
+	close(jobs)
+	wg.Wait()
+	close(results)
+	<-done
 
-	if err != nil {
-		fmt.Printf("Fail to parse roles: %v", err)
-		os.Exit(1)
+	for _, profileName := range refreshed {
+		fmt.Fprintf(messages, "Refreshed profile %s\n", profileName)
 	}
 
-	var roles []role
-	var sResponse samlResponse
-
-	err = xml.Unmarshal(b64, &sResponse)
-
-	if err != nil {
-		fmt.Printf("Fail to unmarshal roles: %v", err)
-		os.Exit(1)
+	for _, result := range failed {
+		fmt.Fprintf(messages, "Fail to refresh profile %s: %v\n", result.profileName, result.err)
 	}
 
-	for _, attr := range sResponse.Assertion.AttributeStatement.Attributes {
-		if attr.Name == "https://aws.amazon.com/SAML/Attributes/Role" {
-			for _, val := range attr.AttributeValues {
-				parts := strings.Split(val.Value, ",")
-
-				if strings.Contains(parts[0], ":role/") {
-					roles = append(roles, role{
-						roleArn:      strings.TrimSpace(parts[0]),
-						principalArn: strings.TrimSpace(parts[1]),
-					})
-				} else {
-					roles = append(roles, role{
-						roleArn:      strings.TrimSpace(parts[1]),
-						principalArn: strings.TrimSpace(parts[0]),
-					})
-				}
-
-			}
-		}
+	if len(refreshed)+len(failed) > 0 {
+		fmt.Fprintf(messages, "%d profile(s) refreshed, %d failed\n", len(refreshed), len(failed))
 	}
 
-	return roles
+	if len(failed) > 0 {
+		os.Exit(azurelogin.ExitCode(failed[0].err))
+	}
 }
 
-func askUserForRoleAndDuration(
-	roles []role,
+// askUserForRolesAndProfiles asks which roles to assume and the profile to write each one to,
+// returning the selected role ARNs mapped to their profile names.
+func askUserForRolesAndProfiles(
+	roles []azurelogin.Role,
 	noPrompt bool,
-	defaultRoleArn string,
-	defaultDurationHours string) (r role, durationHours int32) {
-	durationHoursP, _ := strconv.ParseInt(defaultDurationHours, 10, 32)
-	durationHours = int32(durationHoursP)
+	defaultRoleProfiles map[string]string) map[string]string {
 
 	if len(roles) == 0 {
-		fmt.Println("No roles found in SAML response.")
+		fmt.Fprintln(messages, "No roles found in SAML response.")
 		os.Exit(1)
-	} else if len(roles) == 1 {
-		r = roles[0]
-	} else {
-		if noPrompt && defaultRoleArn != "" {
-			for _, rl := range roles {
-				if rl.roleArn == defaultRoleArn {
-					r = rl
-					break
-				}
+	}
+
+	roleProfiles := map[string]string{}
+
+	if noPrompt {
+		for _, rl := range roles {
+			if p, ok := defaultRoleProfiles[rl.RoleArn]; ok {
+				roleProfiles[rl.RoleArn] = p
 			}
 		}
 
-		if (role{} == r) {
-			var options []string
+		if len(roleProfiles) == 0 {
+			fmt.Fprintln(messages, "None of the roles found in SAML response is mapped to a profile.")
+			os.Exit(1)
+		}
 
-			for _, rl := range roles {
-				options = append(options, rl.roleArn)
-			}
+		return roleProfiles
+	}
 
-			rArn := ""
-			prompt := &survey.Select{
-				Message: "Role:",
-				Options: options,
-				Default: defaultRoleArn,
-			}
-			survey.AskOne(prompt, &rArn, survey.WithValidator(survey.Required))
+	var options []string
+	var defaults []string
 
-			for _, rl := range roles {
-				if rl.roleArn == rArn {
-					r = rl
-					break
-				}
-			}
+	for _, rl := range roles {
+		options = append(options, rl.RoleArn)
+		if _, ok := defaultRoleProfiles[rl.RoleArn]; ok {
+			defaults = append(defaults, rl.RoleArn)
 		}
 	}
 
-	if !(noPrompt && defaultDurationHours != "") {
-		inp := &survey.Input{Message: "Session Duration Hours (up to 12):", Default: defaultDurationHours}
-		hq := ""
+	var roleArns []string
+	prompt := &survey.MultiSelect{
+		Message: "Roles:",
+		Options: options,
+		Default: defaults,
+	}
+	survey.AskOne(prompt, &roleArns, survey.WithValidator(survey.Required), surveyStdio())
 
-		survey.AskOne(inp, &hq, survey.WithValidator(func(val interface{}) error {
-			if str, ok := val.(string); !ok {
-				return errors.New("invalid number")
-			} else if n, err := strconv.ParseInt(str, 10, 32); err != nil || n <= 0 || n > 12 {
-				return errors.New("duration hours must be between 0 and 12")
-			}
-			return nil
-		}))
+	for _, roleArn := range roleArns {
+		defaultProfile, ok := defaultRoleProfiles[roleArn]
+		if !ok {
+			defaultProfile = getDefaultRoleProfileName(roleArn)
+		}
+
+		p := ""
+		inp := &survey.Input{Message: fmt.Sprintf("Profile for %s:", roleArn), Default: defaultProfile}
+		survey.AskOne(inp, &p, survey.WithValidator(survey.Required), surveyStdio())
 
-		durationHoursP, _ = strconv.ParseInt(hq, 10, 32)
-		durationHours = int32(durationHoursP)
+		roleProfiles[roleArn] = p
 	}
-	return
+
+	return roleProfiles
 }
 
-func assumeRole(
-	profileName string,
-	assertion string,
-	role role,
-	durationHours int32,
-	awsNoVerifySsl bool,
-	region *string) {
-
-	durationSeconds := durationHours * 60 * 60
-	stsInput := sts.AssumeRoleWithSAMLInput{
-		PrincipalArn:    &role.principalArn,
-		RoleArn:         &role.roleArn,
-		SAMLAssertion:   &assertion,
-		DurationSeconds: &durationSeconds,
-	}
+// parseRoleProfiles parses a comma separated list of role ARN to profile name mappings,
+// e.g. "arn:aws:iam::123456789012:role/Dev=dev,arn:aws:iam::123456789012:role/Admin=admin".
+func parseRoleProfiles(value *string) map[string]string {
+	roleProfiles := map[string]string{}
 
-	cfg, err := config.LoadDefaultConfig(context.Background())
-	if err != nil {
-		fmt.Printf("Fail to get AWS config: %v", err)
-		os.Exit(1)
+	if value == nil {
+		return roleProfiles
 	}
 
-	if region != nil {
-		cfg.Region = *region
+	for _, mapping := range strings.FieldsFunc(*value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) != "" && strings.TrimSpace(parts[1]) != "" {
+			roleProfiles[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
 	}
 
-	stsClient := sts.NewFromConfig(cfg)
+	return roleProfiles
+}
+
+// getDefaultRoleProfileName returns a profile name made of the account ID and role name of the role ARN.
+func getDefaultRoleProfileName(roleArn string) string {
+	parts := strings.Split(roleArn, ":")
+	if len(parts) != 6 {
+		return roleArn
+	}
+	return parts[4] + "-" + parts[5][strings.LastIndex(parts[5], "/")+1:]
+}
 
-	stsResult, err := stsClient.AssumeRoleWithSAML(context.Background(), &stsInput)
+// exitWithLoginError prints the error, with the remediation hint of login errors, and exits with its exit code.
+func exitWithLoginError(err error) {
+	fmt.Fprintf(messages, "Fail to login: %v\n", err)
 
-	if err != nil {
-		fmt.Printf("Fail to assume role: %v", err)
-		os.Exit(1)
+	var lErr *azurelogin.LoginError
+	if errors.As(err, &lErr) && lErr.Hint != "" {
+		fmt.Fprintln(messages, lErr.Hint)
 	}
 
-	setProfileCredentials(profileName,
-		profileCredentials{
-			AwsAccessKeyID:     *stsResult.Credentials.AccessKeyId,
-			AwsSecretAccessKey: *stsResult.Credentials.SecretAccessKey,
-			AwsSessionToken:    *stsResult.Credentials.SessionToken,
-			AwsExpiration:      (*stsResult.Credentials.Expiration).Format(timeFormat),
-		},
-	)
+	os.Exit(azurelogin.ExitCode(err))
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
@@ -16,8 +20,25 @@ var (
 	noPrompt        bool
 	disableLeakless bool
 	fastPass        bool
+	clearStored     bool
+	credProcess     bool
+	output          string
+	listenAddress   string
+	console         bool
+	consolePath     string
+	openBrowser     bool
+	multipleRoles   bool
+	concurrency     int
+	loginTimeout    time.Duration
+	diagnosticsDir  string
+	command         string
+	commandArgs     []string
+	outputs         []OutputFormat
 )
 
+// messages receives the prompts and messages, stderr when stdout is reserved for the credentials or the command output
+var messages = os.Stdout
+
 func init() {
 	const (
 		profileDefaultValue         = ""
@@ -38,6 +59,28 @@ func init() {
 		disableLeaklessUsage        = "Disable leakless if you are having issues with it"
 		fastPassDefaultValue        = false
 		fastPassUsage               = "Use Okta FastPass verification"
+		clearStoredDefaultValue     = false
+		clearStoredUsage            = "Remove the browser session remembered for the profile (or for all profiles with -all-profiles) and exit"
+		credProcessDefaultValue     = false
+		credProcessUsage            = "Print the credentials as a credential_process JSON document instead of writing them to the credentials file"
+		outputDefaultValue          = string(OUTPUT_CREDENTIALS)
+		outputUsage                 = "Comma separated list of where to output the credentials: 'credentials' to write them to the credentials file, 'bash' for export lines (bash/zsh), 'fish' for set -gx lines, 'powershell' for $env: assignments, 'dotenv' for a dotenv file, 'json' for a JSON document"
+		listenAddressDefaultValue   = "127.0.0.1:0"
+		listenAddressUsage          = "The address the serve subcommand listens on (a random port is chosen when the port is 0)"
+		consoleDefaultValue         = false
+		consoleUsage                = "Print an AWS Management Console sign-in URL for the assumed role"
+		consolePathDefaultValue     = ""
+		consolePathUsage            = "The console page to go to after signing in, as a path (e.g. /s3/home) or a full console URL"
+		openBrowserDefaultValue     = false
+		openBrowserUsage            = "Open the AWS Management Console sign-in URL in the browser"
+		multipleRolesDefaultValue   = false
+		multipleRolesUsage          = "Select several roles and write the credentials of each one to its own profile (mapped with azure_role_profiles)"
+		concurrencyDefaultValue     = 4
+		concurrencyUsage            = "The number of roles assumed in parallel when running for all profiles"
+		loginTimeoutDefaultValue    = 10 * time.Minute
+		loginTimeoutUsage           = "The maximum duration of each browser login to Azure AD, after which it fails with the page it is stuck on (0 to disable). The role prompts and the AWS STS calls are not limited"
+		diagnosticsDirDefaultValue  = ""
+		diagnosticsDirUsage         = "The directory where a screenshot, the HTML of the page, the matched login states and a redacted network log are written when the login fails"
 	)
 
 	flag.StringVar(&profile, "profile", profileDefaultValue, profileUsage)
@@ -54,19 +97,85 @@ func init() {
 	flag.BoolVar(&noPrompt, "no-prompt", noPromptDefaultValue, noPromptUsage)
 	flag.BoolVar(&disableLeakless, "disable-leakless", disableLeaklessDefaultValue, disableLeaklessUsage)
 	flag.BoolVar(&fastPass, "fastpass", fastPassDefaultValue, fastPassUsage)
+	flag.BoolVar(&clearStored, "clear-session", clearStoredDefaultValue, clearStoredUsage)
+	flag.BoolVar(&credProcess, "credential-process", credProcessDefaultValue, credProcessUsage)
+	flag.StringVar(&output, "output", outputDefaultValue, outputUsage)
+	flag.StringVar(&output, "o", outputDefaultValue, outputUsage+" (shorthand)")
+	flag.StringVar(&listenAddress, "listen", listenAddressDefaultValue, listenAddressUsage)
+	flag.BoolVar(&console, "console", consoleDefaultValue, consoleUsage)
+	flag.StringVar(&consolePath, "console-destination", consolePathDefaultValue, consolePathUsage)
+	flag.BoolVar(&openBrowser, "open", openBrowserDefaultValue, openBrowserUsage)
+	flag.BoolVar(&multipleRoles, "multiple-roles", multipleRolesDefaultValue, multipleRolesUsage)
+	flag.IntVar(&concurrency, "concurrency", concurrencyDefaultValue, concurrencyUsage)
+	flag.DurationVar(&loginTimeout, "timeout", loginTimeoutDefaultValue, loginTimeoutUsage)
+	flag.StringVar(&diagnosticsDir, "diagnostics-dir", diagnosticsDirDefaultValue, diagnosticsDirUsage)
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  %[1]s [options]\n  %[1]s exec [options] -- command [args...]\n  %[1]s serve [options]\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+}
 
-	flag.Parse()
-	if flag.NArg() > 0 {
+// parseFlags parses the subcommand and the flags of the command line, exiting when they are invalid.
+func parseFlags() {
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "exec" || args[0] == "serve") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	flag.CommandLine.Parse(args)
+
+	if command == "exec" {
+		commandArgs = flag.Args()
+		if len(commandArgs) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: No command to execute.\n")
+			flag.Usage()
+			os.Exit(2)
+		}
+	} else if flag.NArg() > 0 {
 		fmt.Fprintf(os.Stderr, "Error: Unused command line arguments detected.\n")
 		flag.Usage()
 		os.Exit(2)
 	}
+
+	var err error
+	outputs, err = parseOutputFormats(output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if multipleRoles && (!isCredentialsFileOnly(outputs) || command != "" || credProcess || console || openBrowser) {
+		fmt.Fprintf(os.Stderr, "Error: Multiple roles can only be written to the credentials file.\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if (console || openBrowser) && (allProfiles || command != "" || credProcess) {
+		fmt.Fprintf(os.Stderr, "Error: The console sign-in URL can only be printed when logging in to a single profile.\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if allProfiles && !isCredentialsFileOnly(outputs) {
+		fmt.Fprintf(os.Stderr, "Error: Only the 'credentials' output can be used with all profiles.\n")
+		flag.Usage()
+		os.Exit(2)
+	}
 }
 
 func main() {
+	parseFlags()
+
 	var profileName string
 	isGui := mode == "gui"
 
+	if command != "" || credProcess || !isCredentialsFileOnly(outputs) {
+		messages = os.Stderr
+	}
+
 	if profile != "" {
 		profileName = profile
 	} else if osAWSProfile := os.Getenv("AWS_PROFILE"); osAWSProfile != "" {
@@ -75,13 +184,38 @@ func main() {
 		profileName = "default"
 	}
 
+	// stop the login, closing the browser, on SIGINT/SIGTERM. A second signal terminates right away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	if configure {
 		configureProfile(profileName)
+	} else if clearStored {
+		clearSession(profileName, allProfiles)
+	} else if command == "exec" {
+		execCommand(ctx, profileName, commandArgs, forceRefresh, noVerifySSL, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
+	} else if command == "serve" {
+		serveCredentials(ctx, profileName, listenAddress, loginTimeout, forceRefresh, noVerifySSL, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir)
+	} else if credProcess {
+		credentialProcess(ctx, profileName, forceRefresh, noVerifySSL, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
 	} else {
 		if allProfiles {
-			loginAll(forceRefresh, noVerifySSL, noPrompt, isGui, disableLeakless, fastPass)
+			loginAll(ctx, forceRefresh, noVerifySSL, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout, concurrency)
+		} else if multipleRoles {
+			loginMultipleRoles(ctx, profileName, noVerifySSL, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
 		} else {
-			login(profileName, noVerifySSL, noPrompt, isGui, disableLeakless, fastPass)
+			credentials := login(ctx, profileName, noVerifySSL, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
+
+			writeOutputs(os.Stdout, profileName, credentials, outputs)
+
+			if console || openBrowser {
+				openConsole(credentials, loadProfile(profileName).Region, consolePath, openBrowser)
+			}
 		}
 	}
 
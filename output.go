@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/luneo7/go-aws-azure-login/pkg/azurelogin"
+)
+
+type OutputFormat string
+
+const (
+	OUTPUT_CREDENTIALS OutputFormat = "credentials"
+	OUTPUT_BASH        OutputFormat = "bash"
+	OUTPUT_FISH        OutputFormat = "fish"
+	OUTPUT_POWERSHELL  OutputFormat = "powershell"
+	OUTPUT_DOTENV      OutputFormat = "dotenv"
+	OUTPUT_JSON        OutputFormat = "json"
+)
+
+var outputFormats = []OutputFormat{OUTPUT_CREDENTIALS, OUTPUT_BASH, OUTPUT_FISH, OUTPUT_POWERSHELL, OUTPUT_DOTENV, OUTPUT_JSON}
+
+// parseOutputFormats parses a comma separated list of output formats.
+func parseOutputFormats(value string) ([]OutputFormat, error) {
+	var formats []OutputFormat
+
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		found := false
+		for _, f := range outputFormats {
+			if OutputFormat(v) == f {
+				formats = append(formats, f)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown output format: %s", v)
+		}
+	}
+
+	if len(formats) == 0 {
+		formats = []OutputFormat{OUTPUT_CREDENTIALS}
+	}
+
+	return formats, nil
+}
+
+// isCredentialsFileOnly tells if the output formats only write the credentials file, leaving stdout free for messages.
+func isCredentialsFileOnly(formats []OutputFormat) bool {
+	for _, f := range formats {
+		if f != OUTPUT_CREDENTIALS {
+			return false
+		}
+	}
+	return true
+}
+
+func writeOutputs(w io.Writer, profileName string, credentials azurelogin.Credentials, formats []OutputFormat) {
+	var region *string
+
+	if !isCredentialsFileOnly(formats) {
+		region = loadProfile(profileName).Region
+	}
+
+	for _, f := range formats {
+		if f == OUTPUT_CREDENTIALS {
+			if err := configStore.SetProfileCredentials(profileName, credentials); err != nil {
+				fmt.Fprintf(messages, "Fail to save credentials: %v", err)
+				os.Exit(1)
+			}
+			continue
+		}
+
+		if err := writeOutput(w, f, credentials, region); err != nil {
+			fmt.Fprintf(messages, "Fail to write credentials: %v", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func writeOutput(w io.Writer, format OutputFormat, credentials azurelogin.Credentials, region *string) error {
+	if format == OUTPUT_JSON {
+		return json.NewEncoder(w).Encode(newCredentialProcessOutput(credentials))
+	}
+
+	for _, kv := range getCredentialsEnv(credentials, region) {
+		parts := strings.SplitN(kv, "=", 2)
+		name, value := parts[0], parts[1]
+
+		var line string
+
+		switch format {
+		case OUTPUT_BASH:
+			line = fmt.Sprintf("export %s='%s'", name, strings.ReplaceAll(value, "'", `'\''`))
+		case OUTPUT_FISH:
+			line = fmt.Sprintf("set -gx %s '%s';", name, strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value))
+		case OUTPUT_POWERSHELL:
+			line = fmt.Sprintf("$env:%s = '%s'", name, strings.ReplaceAll(value, "'", "''"))
+		case OUTPUT_DOTENV:
+			line = fmt.Sprintf("%s=%s", name, value)
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getCredentialsEnv returns the environment variables used by the AWS CLI and SDKs for the credentials.
+func getCredentialsEnv(credentials azurelogin.Credentials, region *string) []string {
+	env := []string{
+		"AWS_ACCESS_KEY_ID=" + credentials.AwsAccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + credentials.AwsSecretAccessKey,
+		"AWS_SESSION_TOKEN=" + credentials.AwsSessionToken,
+		"AWS_CREDENTIAL_EXPIRATION=" + credentials.AwsExpiration,
+	}
+
+	if region != nil {
+		env = append(env, "AWS_REGION="+*region)
+	}
+
+	return env
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/luneo7/go-aws-azure-login/pkg/azurelogin"
+)
+
+var quotedCredentials = azurelogin.Credentials{
+	AwsAccessKeyID:     "ASIAFAKEACCESSKEYID",
+	AwsSecretAccessKey: `se'cr\et`,
+	AwsSessionToken:    `to''ken\`,
+	AwsExpiration:      "2030-01-01T00:00:00Z",
+}
+
+func TestWriteOutput(t *testing.T) {
+	region := "us-east-1"
+
+	tests := []struct {
+		format OutputFormat
+		want   string
+	}{
+		{
+			format: OUTPUT_BASH,
+			want: `export AWS_ACCESS_KEY_ID='ASIAFAKEACCESSKEYID'
+export AWS_SECRET_ACCESS_KEY='se'\''cr\et'
+export AWS_SESSION_TOKEN='to'\'''\''ken\'
+export AWS_CREDENTIAL_EXPIRATION='2030-01-01T00:00:00Z'
+export AWS_REGION='us-east-1'
+`,
+		},
+		{
+			format: OUTPUT_FISH,
+			want: `set -gx AWS_ACCESS_KEY_ID 'ASIAFAKEACCESSKEYID';
+set -gx AWS_SECRET_ACCESS_KEY 'se\'cr\\et';
+set -gx AWS_SESSION_TOKEN 'to\'\'ken\\';
+set -gx AWS_CREDENTIAL_EXPIRATION '2030-01-01T00:00:00Z';
+set -gx AWS_REGION 'us-east-1';
+`,
+		},
+		{
+			format: OUTPUT_POWERSHELL,
+			want: `$env:AWS_ACCESS_KEY_ID = 'ASIAFAKEACCESSKEYID'
+$env:AWS_SECRET_ACCESS_KEY = 'se''cr\et'
+$env:AWS_SESSION_TOKEN = 'to''''ken\'
+$env:AWS_CREDENTIAL_EXPIRATION = '2030-01-01T00:00:00Z'
+$env:AWS_REGION = 'us-east-1'
+`,
+		},
+		{
+			format: OUTPUT_DOTENV,
+			want: `AWS_ACCESS_KEY_ID=ASIAFAKEACCESSKEYID
+AWS_SECRET_ACCESS_KEY=se'cr\et
+AWS_SESSION_TOKEN=to''ken\
+AWS_CREDENTIAL_EXPIRATION=2030-01-01T00:00:00Z
+AWS_REGION=us-east-1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var b bytes.Buffer
+			if err := writeOutput(&b, tt.format, quotedCredentials, &region); err != nil {
+				t.Fatalf("writeOutput() error = %v", err)
+			}
+
+			if b.String() != tt.want {
+				t.Errorf("writeOutput() =\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteOutputEvaluatedBySh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the bash output is evaluated with sh")
+	}
+
+	var b bytes.Buffer
+	if err := writeOutput(&b, OUTPUT_BASH, quotedCredentials, nil); err != nil {
+		t.Fatalf("writeOutput() error = %v", err)
+	}
+
+	out, err := exec.Command("sh", "-c", b.String()+`printf '%s\n%s' "$AWS_SECRET_ACCESS_KEY" "$AWS_SESSION_TOKEN"`).Output()
+	if err != nil {
+		t.Fatalf("sh error = %v", err)
+	}
+
+	if want := quotedCredentials.AwsSecretAccessKey + "\n" + quotedCredentials.AwsSessionToken; string(out) != want {
+		t.Errorf("sh evaluated the credentials to %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,112 @@
+package azurelogin
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Azure clouds of the azure_cloud profile setting
+const (
+	AZURE_CLOUD_PUBLIC = "public"
+	AZURE_CLOUD_USGOV  = "usgov"
+	AZURE_CLOUD_CHINA  = "china"
+)
+
+// AWS partitions
+const (
+	AWS_PARTITION     = "aws"
+	AWS_GOV_PARTITION = "aws-us-gov"
+	AWS_CN_PARTITION  = "aws-cn"
+)
+
+// AzureCloud is an Azure cloud, with its own Azure AD hosts.
+type AzureCloud struct {
+	Name string
+	// AuthorityHost is the host of the Azure AD login pages
+	AuthorityHost string
+	// SSOHost is the host of the Azure AD Seamless SSO, allowed to authenticate with Kerberos
+	SSOHost string
+	// AWSPartitions are the AWS partitions the tenants of the cloud can log in to
+	AWSPartitions []string
+}
+
+var azureClouds = map[string]AzureCloud{
+	AZURE_CLOUD_PUBLIC: {
+		Name:          AZURE_CLOUD_PUBLIC,
+		AuthorityHost: AZURE_AUTHORITY_HOST,
+		SSOHost:       AZURE_AD_SSO,
+		AWSPartitions: []string{AWS_PARTITION, AWS_GOV_PARTITION, AWS_CN_PARTITION},
+	},
+	AZURE_CLOUD_USGOV: {
+		Name:          AZURE_CLOUD_USGOV,
+		AuthorityHost: "login.microsoftonline.us",
+		SSOHost:       "autologon.microsoft.us",
+		AWSPartitions: []string{AWS_GOV_PARTITION},
+	},
+	AZURE_CLOUD_CHINA: {
+		Name:          AZURE_CLOUD_CHINA,
+		AuthorityHost: "login.partner.microsoftonline.cn",
+		SSOHost:       "autologon.partner.microsoftonline.cn",
+		AWSPartitions: []string{AWS_CN_PARTITION},
+	},
+}
+
+// GetAWSPartition returns the AWS partition of the region, the commercial one when it is not set.
+func GetAWSPartition(region *string) string {
+	if region != nil {
+		if strings.HasPrefix(*region, "us-gov") {
+			return AWS_GOV_PARTITION
+		} else if strings.HasPrefix(*region, "cn-") {
+			return AWS_CN_PARTITION
+		}
+	}
+
+	return AWS_PARTITION
+}
+
+// AzureCloud returns the Azure cloud of the azure_cloud setting, the public one when it is not set or unknown
+// (see ValidateAzureCloud).
+func (p ProfileConfig) AzureCloud() AzureCloud {
+	if p.AzureCloudName != nil {
+		if cloud, ok := azureClouds[strings.ToLower(*p.AzureCloudName)]; ok {
+			return cloud
+		}
+	}
+
+	return azureClouds[AZURE_CLOUD_PUBLIC]
+}
+
+// ValidateAzureCloud checks that the azure_cloud setting is known, and that its tenants can log in to the
+// AWS partition of the region.
+func (p ProfileConfig) ValidateAzureCloud() error {
+	if p.AzureCloudName != nil && *p.AzureCloudName != "" {
+		if _, ok := azureClouds[strings.ToLower(*p.AzureCloudName)]; !ok {
+			return &LoginError{
+				Message:     fmt.Sprintf("unknown azure_cloud %q", *p.AzureCloudName),
+				Explanation: "The profile configuration is invalid",
+				Hint:        fmt.Sprintf("Set azure_cloud to %s, %s or %s.", AZURE_CLOUD_PUBLIC, AZURE_CLOUD_USGOV, AZURE_CLOUD_CHINA),
+				ExitCode:    EXIT_CONFIGURATION_ERROR,
+			}
+		}
+	}
+
+	cloud := p.AzureCloud()
+	partition := GetAWSPartition(p.Region)
+
+	if !slices.Contains(cloud.AWSPartitions, partition) {
+		region := ""
+		if p.Region != nil {
+			region = *p.Region
+		}
+
+		return &LoginError{
+			Message:     fmt.Sprintf("the %s Azure cloud cannot log in to the %s AWS partition of region %q", cloud.Name, partition, region),
+			Explanation: "The profile configuration is invalid",
+			Hint:        fmt.Sprintf("Set region to a region of the %s partition, or fix azure_cloud.", strings.Join(cloud.AWSPartitions, " or ")),
+			ExitCode:    EXIT_CONFIGURATION_ERROR,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,402 @@
+package azurelogin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+	"gopkg.in/ini.v1"
+)
+
+// TimeFormat is the format of the credentials expiration dates
+const TimeFormat = "2006-01-02T15:04:05.000Z"
+
+const tagName = "config"
+
+const refreshLimitInMs int64 = 11 * 60 * 1000
+
+// ProfileConfig is the configuration of a profile in the AWS config file.
+type ProfileConfig struct {
+	AzureTenantID              string  `config:"azure_tenant_id" survey:"tenantId"`
+	AzureAppIDUri              string  `config:"azure_app_id_uri" survey:"appIdUri"`
+	AzureDefaultUsername       string  `config:"azure_default_username" survey:"username"`
+	AzureDefaultPassword       *string `config:"azure_default_password"`
+	AzureDefaultRoleArn        string  `config:"azure_default_role_arn" survey:"defaultRoleArn"`
+	AzureDefaultDurationHours  string  `config:"azure_default_duration_hours" survey:"defaultDurationHours"`
+	Region                     *string `config:"region"`
+	AzureDefaultRememberMe     bool    `config:"azure_default_remember_me" survey:"rememberMe"`
+	OktaDefaultUsername        *string `config:"okta_default_username" survey:"oktaUsername"`
+	OktaDefaultPassword        *string `config:"okta_default_password" survey:"oktaPassword"`
+	AzureRoleProfiles          *string `config:"azure_role_profiles"`
+	AzureUseSecretStore        bool    `config:"azure_use_secret_store" survey:"useSecretStore"`
+	AzurePasswordCommand       *string `config:"azure_password_command"`
+	OktaPasswordCommand        *string `config:"okta_password_command"`
+	AzureAuthorityHost         *string `config:"azure_authority_host"`
+	AwsSamlAcsUrl              *string `config:"aws_saml_acs_url"`
+	AwsStsEndpoint             *string `config:"aws_sts_endpoint"`
+	AzureCloudName             *string `config:"azure_cloud"`
+	AzureVerifySAML            bool    `config:"azure_verify_saml" survey:"verifySaml"`
+	AzureSAMLCertificate       *string `config:"azure_saml_certificate"`
+	AzureFederationMetadataURL *string `config:"azure_federation_metadata_url"`
+}
+
+// Credentials are the AWS credentials of an assumed role, as written to the AWS credentials file.
+type Credentials struct {
+	AwsAccessKeyID     string `config:"aws_access_key_id"`
+	AwsSecretAccessKey string `config:"aws_secret_access_key"`
+	AwsSessionToken    string `config:"aws_session_token"`
+	AwsExpiration      string `config:"aws_expiration"`
+}
+
+// ConfigStore reads and writes the profiles of the AWS config and credentials files.
+type ConfigStore struct {
+	ConfigFile      string
+	CredentialsFile string
+}
+
+// NewConfigStore returns the store of the AWS config and credentials files, honoring the
+// AWS_CONFIG_FILE and AWS_SHARED_CREDENTIALS_FILE environment variables.
+func NewConfigStore() *ConfigStore {
+	return &ConfigStore{
+		ConfigFile:      Paths[CONFIG],
+		CredentialsFile: Paths[CREDENTIALS],
+	}
+}
+
+// SetProfileConfig writes the configuration of the profile to the AWS config file.
+func (s *ConfigStore) SetProfileConfig(profileName string, values ProfileConfig) error {
+	sectionName := getSectionName(profileName)
+
+	return update(s.ConfigFile, func(config *ini.File) {
+		section := config.Section(sectionName)
+
+		setSectionValues(section, values)
+	})
+}
+
+// GetProfileConfig reads the configuration of the profile from the AWS config file.
+func (s *ConfigStore) GetProfileConfig(profileName string) (ProfileConfig, error) {
+	sectionName := getSectionName(profileName)
+
+	config, err := load(s.ConfigFile)
+	if err != nil {
+		return ProfileConfig{}, err
+	}
+
+	section := config.Section(sectionName)
+
+	azureDefaultRememberMe, err := strconv.ParseBool(section.Key("azure_default_remember_me").Value())
+
+	if err != nil {
+		azureDefaultRememberMe = false
+	}
+
+	azureUseSecretStore, err := strconv.ParseBool(section.Key("azure_use_secret_store").Value())
+
+	if err != nil {
+		azureUseSecretStore = false
+	}
+
+	azureVerifySAML, err := strconv.ParseBool(section.Key("azure_verify_saml").Value())
+
+	if err != nil {
+		azureVerifySAML = false
+	}
+
+	return ProfileConfig{
+		AzureTenantID:              section.Key("azure_tenant_id").Value(),
+		AzureAppIDUri:              section.Key("azure_app_id_uri").Value(),
+		AzureDefaultUsername:       section.Key("azure_default_username").Value(),
+		AzureDefaultPassword:       StringToPointer(section.Key("azure_default_password").Value()),
+		AzureDefaultRoleArn:        section.Key("azure_default_role_arn").Value(),
+		AzureDefaultDurationHours:  section.Key("azure_default_duration_hours").Value(),
+		Region:                     StringToPointer(section.Key("region").Value()),
+		AzureDefaultRememberMe:     azureDefaultRememberMe,
+		OktaDefaultUsername:        StringToPointer(section.Key("okta_default_username").Value()),
+		OktaDefaultPassword:        StringToPointer(section.Key("okta_default_password").Value()),
+		AzureRoleProfiles:          StringToPointer(section.Key("azure_role_profiles").Value()),
+		AzureUseSecretStore:        azureUseSecretStore,
+		AzurePasswordCommand:       StringToPointer(section.Key("azure_password_command").Value()),
+		OktaPasswordCommand:        StringToPointer(section.Key("okta_password_command").Value()),
+		AzureAuthorityHost:         StringToPointer(section.Key("azure_authority_host").Value()),
+		AwsSamlAcsUrl:              StringToPointer(section.Key("aws_saml_acs_url").Value()),
+		AwsStsEndpoint:             StringToPointer(section.Key("aws_sts_endpoint").Value()),
+		AzureCloudName:             StringToPointer(section.Key("azure_cloud").Value()),
+		AzureVerifySAML:            azureVerifySAML,
+		AzureSAMLCertificate:       StringToPointer(section.Key("azure_saml_certificate").Value()),
+		AzureFederationMetadataURL: StringToPointer(section.Key("azure_federation_metadata_url").Value()),
+	}, nil
+}
+
+// LoadProfile returns the configuration of the profile, overridden by the environment variables named after its keys.
+func (s *ConfigStore) LoadProfile(profileName string) (ProfileConfig, error) {
+	profile, err := s.GetProfileConfig(profileName)
+	if err != nil {
+		return ProfileConfig{}, err
+	}
+
+	envProfile := LoadProfileFromEnv()
+
+	if (envProfile != ProfileConfig{}) {
+		v := reflect.ValueOf(&profile).Elem()
+		vEnv := reflect.ValueOf(&envProfile).Elem()
+		t := v.Type()
+
+		for i := 0; i < t.NumField(); i++ {
+			value := v.Field(i)
+			envValue := vEnv.Field(i)
+
+			if envValue.Kind() == reflect.Ptr {
+				if !envValue.IsNil() {
+					value.Set(reflect.ValueOf(envValue.Interface()))
+				}
+			} else if envValue.Kind() == reflect.Bool {
+				if value.Interface().(bool) != envValue.Interface().(bool) {
+					value.SetBool(envValue.Interface().(bool))
+				}
+			} else {
+				nValue := envValue.Interface().(string)
+				if value.Interface().(string) != nValue && nValue != "" {
+					value.SetString(envValue.Interface().(string))
+				}
+			}
+		}
+	}
+
+	return profile, nil
+}
+
+// LoadProfileFromEnv returns the profile configuration set by the environment variables named after its keys.
+func LoadProfileFromEnv() ProfileConfig {
+	envVars := []string{
+		"azure_tenant_id",
+		"azure_app_id_uri",
+		"azure_default_username",
+		"azure_default_password",
+		"azure_default_role_arn",
+		"azure_default_duration_hours",
+		"region",
+		"okta_default_username",
+		"okta_default_password",
+		"azure_role_profiles",
+		"azure_password_command",
+		"okta_password_command",
+		"azure_authority_host",
+		"aws_saml_acs_url",
+		"aws_sts_endpoint",
+		"azure_cloud",
+		"azure_saml_certificate",
+		"azure_federation_metadata_url",
+	}
+
+	profile := ProfileConfig{}
+
+	v := reflect.ValueOf(&profile).Elem()
+	t := v.Type()
+
+	for _, envVar := range envVars {
+		val, exists := os.LookupEnv(envVar)
+		if exists {
+			for i := 0; i < v.NumField(); i++ {
+				tag := t.Field(i).Tag.Get(tagName)
+				if tag == envVar {
+					f := v.Field(i)
+					if f.Kind() == reflect.String {
+						f.SetString(val)
+					} else if f.Kind() == reflect.Ptr {
+						f.Set(reflect.ValueOf(&val))
+					}
+				}
+			}
+		}
+	}
+
+	return profile
+}
+
+// IsProfileAboutToExpire tells if the credentials of the profile in the AWS credentials file are missing or about to expire.
+func (s *ConfigStore) IsProfileAboutToExpire(profileName string) (bool, error) {
+	config, err := load(s.CredentialsFile)
+	if err != nil {
+		return false, err
+	}
+
+	section := config.Section(profileName)
+
+	aws_expiration := section.Key("aws_expiration").Value()
+
+	expirationDate := time.Now()
+
+	if aws_expiration != "" {
+		var err error
+		expirationDate, err = time.Parse(TimeFormat, aws_expiration)
+		if err != nil {
+			return false, fmt.Errorf("invalid profile expiration: %w", err)
+		}
+	}
+
+	return IsAboutToExpire(expirationDate), nil
+}
+
+// IsAboutToExpire tells if credentials expiring at the date should be refreshed.
+func IsAboutToExpire(expirationDate time.Time) bool {
+	timeDifference := time.Until(expirationDate)
+
+	return timeDifference.Milliseconds() < refreshLimitInMs
+}
+
+// SetProfileCredentials writes the credentials of the profile to the AWS credentials file.
+func (s *ConfigStore) SetProfileCredentials(profileName string, values Credentials) error {
+	return update(s.CredentialsFile, func(config *ini.File) {
+		section := config.Section(profileName)
+
+		setSectionValues(section, values)
+	})
+}
+
+// GetAllProfileNames returns the names of the profiles of the AWS config file.
+func (s *ConfigStore) GetAllProfileNames() ([]string, error) {
+	config, err := load(s.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := config.Sections()
+
+	var profiles []string
+
+	for _, section := range sections {
+		if section != nil {
+			profiles = append(profiles, strings.ReplaceAll(section.Name(), "profile ", ""))
+		}
+	}
+
+	return profiles, nil
+}
+
+func getSectionName(profileName string) string {
+	sectionName := "default"
+	if profileName != "default" {
+		sectionName = fmt.Sprintf("profile %s", profileName)
+	}
+	return sectionName
+}
+
+func setSectionValues(section *ini.Section, values interface{}) {
+	v := reflect.ValueOf(values)
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+
+		tag := field.Tag.Get(tagName)
+
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() || value.Elem().Interface().(string) == "" {
+				section.DeleteKey(tag)
+			} else {
+				section.NewKey(tag, value.Elem().Interface().(string))
+			}
+		} else {
+			if value.Kind() == reflect.Bool {
+				section.NewKey(tag, strconv.FormatBool(value.Interface().(bool)))
+			} else {
+				section.NewKey(tag, value.Interface().(string))
+			}
+		}
+	}
+}
+
+func load(p string) (*ini.File, error) {
+	cfg, err := ini.LooseLoad(p)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read file: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// update loads the file, applies the changes and saves it while holding an advisory lock,
+// so that concurrent runs don't overwrite each other's changes.
+func update(p string, modify func(data *ini.File)) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return fmt.Errorf("fail to create directory: %w", err)
+	}
+
+	lock := flock.New(p + ".lock")
+	if err := lock.Lock(); err != nil {
+		return fmt.Errorf("fail to lock file: %w", err)
+	}
+	defer lock.Unlock()
+
+	data, err := load(p)
+	if err != nil {
+		return err
+	}
+
+	modify(data)
+
+	return save(p, data)
+}
+
+// save writes the file to a temporary file renamed over the original one, so that it is never left
+// partially written. The permissions of the original file are kept, new files are only readable by the user.
+func save(p string, data *ini.File) error {
+	if data == nil {
+		return errors.New("you must provide a data for saving")
+	}
+
+	if target, err := filepath.EvalSymlinks(p); err == nil {
+		p = target
+	}
+
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(p); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("fail to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := data.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("fail to write file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("fail to write file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("fail to write file: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("fail to set file permissions: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("fail to replace file: %w", err)
+	}
+
+	return nil
+}
+
+// StringToPointer returns a pointer to the string, or nil when it is empty.
+func StringToPointer(v string) *string {
+	if v != "" {
+		return &v
+	}
+	return nil
+}
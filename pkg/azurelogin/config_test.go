@@ -0,0 +1,141 @@
+package azurelogin
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+func TestSetProfileCredentialsKeepsFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the file modes and symlinks are not the same on Windows")
+	}
+
+	credentials := Credentials{
+		AwsAccessKeyID:     "ASIAFAKEACCESSKEYID",
+		AwsSecretAccessKey: "fake-secret-access-key",
+		AwsSessionToken:    "fake-session-token",
+		AwsExpiration:      "2030-01-01T00:00:00Z",
+	}
+
+	tests := []struct {
+		name     string
+		existing os.FileMode
+		symlink  bool
+		want     os.FileMode
+	}{
+		{name: "new file", want: 0600},
+		{name: "existing file", existing: 0644, want: 0644},
+		{name: "symlink", existing: 0644, symlink: true, want: 0644},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p := filepath.Join(dir, "credentials")
+			target := p
+
+			if tt.symlink {
+				target = filepath.Join(t.TempDir(), "credentials")
+				if err := os.Symlink(target, p); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.existing != 0 {
+				if err := os.WriteFile(target, []byte("[other]\naws_access_key_id = other\n"), tt.existing); err != nil {
+					t.Fatal(err)
+				}
+				// the umask may have changed the mode of the file
+				if err := os.Chmod(target, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			store := &ConfigStore{CredentialsFile: p}
+			if err := store.SetProfileCredentials("foo", credentials); err != nil {
+				t.Fatalf("SetProfileCredentials() error = %v", err)
+			}
+
+			if tt.symlink {
+				if link, err := os.Readlink(p); err != nil || link != target {
+					t.Errorf("Readlink() = %s, %v, want the symlink to %s kept", link, err, target)
+				}
+			}
+
+			info, err := os.Stat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Mode().Perm() != tt.want {
+				t.Errorf("file mode = %v, want %v", info.Mode().Perm(), tt.want)
+			}
+
+			data, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(string(data), "fake-session-token") {
+				t.Errorf("file = %s, want the credentials of the profile", data)
+			}
+
+			if tt.existing != 0 && !strings.Contains(string(data), "[other]") {
+				t.Errorf("file = %s, want the other profiles kept", data)
+			}
+
+			for _, d := range []string{dir, filepath.Dir(target)} {
+				if tmp, _ := filepath.Glob(filepath.Join(d, "*.tmp")); len(tmp) != 0 {
+					t.Errorf("temporary files %v left", tmp)
+				}
+			}
+		})
+	}
+}
+
+func TestSetProfileCredentialsWaitsForLock(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "credentials")
+
+	lock := flock.New(p + ".lock")
+	if err := lock.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- (&ConfigStore{CredentialsFile: p}).SetProfileCredentials("foo", Credentials{AwsAccessKeyID: "ASIAFAKEACCESSKEYID"})
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("SetProfileCredentials() = %v while the file is locked, want it to wait", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := os.Stat(p); err == nil {
+		t.Error("file written while locked")
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("SetProfileCredentials() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetProfileCredentials() still waiting after the lock was released")
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		t.Errorf("file not written: %v", err)
+	}
+}
@@ -0,0 +1,410 @@
+package azurelogin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+const REDACTED = "[REDACTED]"
+
+// Names of the headers, query parameters and form fields whose values are never written to the diagnostics
+var (
+	sensitiveHeaderRegexp = regexp.MustCompile(`(?i)^(cookie|set-cookie|authorization|proxy-authorization|x-okta-xsrftoken)$`)
+	sensitiveFieldRegexp  = regexp.MustCompile(`(?i)pass|pwd|secret|token|saml|assertion|otc|code|canary|statehandle`)
+	sensitiveConfigRegexp = regexp.MustCompile(`("(?:sFT|sFTTag|canary|apiCanary|sCtx|stateToken)"\s*:\s*)"[^"]*"`)
+)
+
+// loginDiagnostics records the states matched and the network requests made during a login, to write
+// them with a screenshot and the HTML of the page when the login fails. A nil loginDiagnostics records nothing.
+type loginDiagnostics struct {
+	mu       sync.Mutex
+	dir      string
+	out      io.Writer
+	started  time.Time
+	states   []diagnosticsState
+	entries  []*harEntry
+	requests map[proto.NetworkRequestID]*harEntry
+}
+
+type diagnosticsState struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+type diagnosticsReport struct {
+	Error    string             `json:"error"`
+	Started  time.Time          `json:"started"`
+	Failed   time.Time          `json:"failed"`
+	URL      string             `json:"url,omitempty"`
+	Title    string             `json:"title,omitempty"`
+	States   []diagnosticsState `json:"states"`
+	Warnings []string           `json:"warnings,omitempty"`
+}
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/), without the response contents
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// newLoginDiagnostics returns the diagnostics of a login written to dir, reported to out, or nil when dir is empty.
+func newLoginDiagnostics(dir string, out io.Writer) *loginDiagnostics {
+	if dir == "" {
+		return nil
+	}
+
+	return &loginDiagnostics{
+		dir:      dir,
+		out:      out,
+		started:  time.Now(),
+		requests: map[proto.NetworkRequestID]*harEntry{},
+	}
+}
+
+// recordNetwork records the requests of the page until the context is done.
+func (d *loginDiagnostics) recordNetwork(ctx context.Context, pg *rod.Page) {
+	if d == nil {
+		return
+	}
+
+	go pg.Context(ctx).EachEvent(func(e *proto.NetworkRequestWillBeSent) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if entry, ok := d.requests[e.RequestID]; ok && e.RedirectResponse != nil {
+			entry.Response = newHarResponse(e.RedirectResponse)
+		}
+
+		entry := &harEntry{
+			StartedDateTime: e.WallTime.Time(),
+			Request:         newHarRequest(e.Request),
+			Response:        harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+		}
+
+		d.entries = append(d.entries, entry)
+		d.requests[e.RequestID] = entry
+	}, func(e *proto.NetworkResponseReceived) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if entry, ok := d.requests[e.RequestID]; ok {
+			entry.Response = newHarResponse(e.Response)
+			entry.Time = float64(time.Since(entry.StartedDateTime).Milliseconds())
+		}
+	}, func(e *proto.NetworkLoadingFailed) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if entry, ok := d.requests[e.RequestID]; ok {
+			entry.Response.Comment = e.ErrorText
+		}
+	})()
+}
+
+// addState records that the state matched the page.
+func (d *loginDiagnostics) addState(name string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.states = append(d.states, diagnosticsState{Name: name, Time: time.Now()})
+}
+
+// write writes the screenshot and HTML of the page, the report of the failed login and the network log
+// in a new directory, best effort.
+func (d *loginDiagnostics) write(pg *rod.Page, loginErr error) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dir := filepath.Join(d.dir, time.Now().Format("20060102-150405.000"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fmt.Fprintf(d.out, "Fail to create diagnostics directory: %v\n", err)
+		return
+	}
+
+	report := diagnosticsReport{
+		Error:   loginErr.Error(),
+		Started: d.started,
+		Failed:  time.Now(),
+		States:  d.states,
+	}
+
+	// the page context may be done, use a new one
+	p := pg.Context(context.Background()).Timeout(30 * time.Second)
+
+	if info, err := p.Info(); err == nil {
+		report.URL = redactURL(info.URL)
+		report.Title = info.Title
+	} else {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("fail to get page info: %v", err))
+	}
+
+	if img, err := p.Screenshot(true, nil); err == nil {
+		report.Warnings = appendWriteWarning(report.Warnings, dir, "screenshot.png", img)
+	} else {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("fail to take screenshot: %v", err))
+	}
+
+	if html, err := getRedactedHTML(p); err == nil {
+		report.Warnings = appendWriteWarning(report.Warnings, dir, "page.html", []byte(html))
+	} else {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("fail to get page HTML: %v", err))
+	}
+
+	network, _ := json.MarshalIndent(har{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: SECRET_SERVICE},
+		Entries: d.entries,
+	}}, "", "  ")
+	report.Warnings = appendWriteWarning(report.Warnings, dir, "network.har", network)
+
+	data, _ := json.MarshalIndent(report, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, "report.json"), data, 0600); err != nil {
+		fmt.Fprintf(d.out, "Fail to write diagnostics: %v\n", err)
+		return
+	}
+
+	fmt.Fprintf(d.out, "Diagnostics written to %s\n", dir)
+}
+
+func appendWriteWarning(warnings []string, dir string, name string, data []byte) []string {
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		return append(warnings, fmt.Sprintf("fail to write %s: %v", name, err))
+	}
+	return warnings
+}
+
+// getRedactedHTML returns the HTML of the page without the values of the password and hidden inputs
+// (flow tokens, SAMLResponse) and the tokens of the Azure page configuration.
+func getRedactedHTML(pg *rod.Page) (string, error) {
+	res, err := pg.Eval(`() => {
+		const html = document.documentElement.cloneNode(true);
+		html.querySelectorAll('input[type=password],input[type=hidden]').forEach(e => e.setAttribute('value', '` + REDACTED + `'));
+		return html.outerHTML;
+	}`)
+	if err != nil {
+		return "", err
+	}
+
+	return sensitiveConfigRegexp.ReplaceAllString(res.Value.Str(), `$1"`+REDACTED+`"`), nil
+}
+
+func newHarRequest(r *proto.NetworkRequest) harRequest {
+	req := harRequest{
+		Method:      r.Method,
+		URL:         redactURL(r.URL),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     redactHeaders(r.Headers),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+
+	if u, err := url.Parse(req.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				req.QueryString = append(req.QueryString, harNameValue{Name: name, Value: value})
+			}
+		}
+	}
+
+	if r.HasPostData || r.PostData != "" {
+		mimeType := ""
+		for name, value := range r.Headers {
+			if strings.EqualFold(name, "content-type") {
+				mimeType = value.Str()
+			}
+		}
+		req.PostData = &harPostData{MimeType: mimeType, Text: redactBody(mimeType, r.PostData)}
+	}
+
+	return req
+}
+
+func newHarResponse(r *proto.NetworkResponse) harResponse {
+	res := harResponse{
+		Status:      r.Status,
+		StatusText:  r.StatusText,
+		HTTPVersion: r.Protocol,
+		Cookies:     []harNameValue{},
+		Headers:     redactHeaders(r.Headers),
+		Content:     harContent{Size: int(r.EncodedDataLength), MimeType: r.MIMEType},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+
+	for _, h := range res.Headers {
+		if strings.EqualFold(h.Name, "location") {
+			res.RedirectURL = h.Value
+		}
+	}
+
+	return res
+}
+
+func redactHeaders(headers proto.NetworkHeaders) []harNameValue {
+	list := []harNameValue{}
+
+	for name, value := range headers {
+		v := value.Str()
+		if sensitiveHeaderRegexp.MatchString(name) {
+			v = REDACTED
+		} else if strings.EqualFold(name, "location") || strings.EqualFold(name, "referer") {
+			v = redactURL(v)
+		}
+		list = append(list, harNameValue{Name: name, Value: v})
+	}
+
+	return list
+}
+
+// redactURL redacts the values of the sensitive query parameters of the URL.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return rawURL
+	}
+
+	u.RawQuery = redactValues(query).Encode()
+	return u.String()
+}
+
+// redactBody redacts the sensitive fields of form and JSON bodies, and the whole body of other types.
+func redactBody(mimeType string, body string) string {
+	if body == "" {
+		return ""
+	}
+
+	if strings.Contains(mimeType, "application/x-www-form-urlencoded") {
+		if values, err := url.ParseQuery(body); err == nil {
+			return redactValues(values).Encode()
+		}
+	}
+
+	if strings.Contains(mimeType, "json") {
+		var value interface{}
+		if err := json.Unmarshal([]byte(body), &value); err == nil {
+			data, _ := json.Marshal(redactJSON(value))
+			return string(data)
+		}
+	}
+
+	return REDACTED
+}
+
+func redactValues(values url.Values) url.Values {
+	for name := range values {
+		if sensitiveFieldRegexp.MatchString(name) {
+			values[name] = []string{REDACTED}
+		}
+	}
+	return values
+}
+
+func redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if sensitiveFieldRegexp.MatchString(name) {
+				v[name] = REDACTED
+			} else {
+				v[name] = redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactJSON(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,188 @@
+package azurelogin
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+var redactedQuery = url.QueryEscape(REDACTED)
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		body     string
+		want     string
+	}{
+		{
+			name:     "azure password form",
+			mimeType: "application/x-www-form-urlencoded",
+			body:     "login=jane%40example.com&passwd=secret&flowToken=abc",
+			want:     "flowToken=" + redactedQuery + "&login=jane%40example.com&passwd=" + redactedQuery,
+		},
+		{
+			name:     "okta password form",
+			mimeType: "application/x-www-form-urlencoded; charset=UTF-8",
+			body:     "credentials.passcode=secret&rememberMe=true",
+			want:     "credentials.passcode=" + redactedQuery + "&rememberMe=true",
+		},
+		{
+			name:     "saml post",
+			mimeType: "application/x-www-form-urlencoded",
+			body:     "SAMLResponse=PHNhbWw%2B&RelayState=",
+			want:     "RelayState=&SAMLResponse=" + redactedQuery,
+		},
+		{
+			name:     "okta json",
+			mimeType: "application/json",
+			body:     `{"identifier":"jane","credentials":{"passcode":"secret"},"stateHandle":"abc"}`,
+			want:     `{"credentials":{"passcode":"` + REDACTED + `"},"identifier":"jane","stateHandle":"` + REDACTED + `"}`,
+		},
+		{
+			name:     "json array",
+			mimeType: "application/ion+json",
+			body:     `[{"flowToken":"abc","type":"otc"}]`,
+			want:     `[{"flowToken":"` + REDACTED + `","type":"otc"}]`,
+		},
+		{
+			name:     "other type",
+			mimeType: "text/plain",
+			body:     "passwd=secret",
+			want:     REDACTED,
+		},
+		{
+			name:     "invalid json",
+			mimeType: "application/json",
+			body:     `{"passwd":`,
+			want:     REDACTED,
+		},
+		{
+			name:     "empty",
+			mimeType: "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactBody(tt.mimeType, tt.body); got != tt.want {
+				t.Errorf("redactBody() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{
+			name: "saml request",
+			url:  "https://login.microsoftonline.com/tenant/saml2?SAMLRequest=abc&sso_reload=true",
+			want: "https://login.microsoftonline.com/tenant/saml2?SAMLRequest=" + redactedQuery + "&sso_reload=true",
+		},
+		{
+			name: "authorization code",
+			url:  "https://example.okta.com/callback?code=abc&state=xyz&stateToken=def",
+			want: "https://example.okta.com/callback?code=" + redactedQuery + "&state=xyz&stateToken=" + redactedQuery,
+		},
+		{
+			name: "no query",
+			url:  "https://login.microsoftonline.com/common/login",
+			want: "https://login.microsoftonline.com/common/login",
+		},
+		{
+			name: "invalid query",
+			url:  "https://example.com/?passwd=%zz",
+			want: "https://example.com/?passwd=%zz",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactURL(tt.url); got != tt.want {
+				t.Errorf("redactURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	var headers proto.NetworkHeaders
+	if err := json.Unmarshal([]byte(`{
+		"Set-Cookie": "ESTSAUTH=secret; path=/",
+		"cookie": "ESTSAUTHPERSISTENT=secret",
+		"Authorization": "Bearer secret",
+		"X-Okta-XsrfToken": "secret",
+		"Location": "https://signin.aws.amazon.com/saml?SAMLResponse=secret",
+		"Content-Type": "text/html"
+	}`), &headers); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"Set-Cookie":       REDACTED,
+		"cookie":           REDACTED,
+		"Authorization":    REDACTED,
+		"X-Okta-XsrfToken": REDACTED,
+		"Location":         "https://signin.aws.amazon.com/saml?SAMLResponse=" + redactedQuery,
+		"Content-Type":     "text/html",
+	}
+
+	got := redactHeaders(headers)
+	if len(got) != len(want) {
+		t.Fatalf("redactHeaders() = %v, want %d headers", got, len(want))
+	}
+
+	for _, h := range got {
+		if h.Value != want[h.Name] {
+			t.Errorf("redactHeaders() %s = %s, want %s", h.Name, h.Value, want[h.Name])
+		}
+	}
+}
+
+func TestGetRedactedHTML(t *testing.T) {
+	requireBrowser(t)
+
+	u := launcher.New().Headless(true).MustLaunch()
+	browser := rod.New().ControlURL(u).MustConnect()
+	defer browser.Close()
+
+	pg := browser.MustPage()
+	pg.MustSetDocumentContent(`<html><head><script>$Config={"sFT":"secret-flow-token","canary":"secret-canary","urlPost":"/login"};</script></head><body>
+<form method="post">
+  <input type="email" name="loginfmt" value="jane@example.com">
+  <input type="password" name="passwd" value="secret-password">
+  <input type="password" name="credentials.passcode" value="secret-passcode">
+  <input type="hidden" name="flowToken" value="secret-token">
+  <input type="hidden" name="SAMLResponse" value="secret-saml">
+</form>
+</body></html>`)
+
+	// the values typed in the inputs are properties, not attributes
+	pg.MustElement(`input[name=passwd]`).MustInput("typed-password")
+
+	html, err := getRedactedHTML(pg)
+	if err != nil {
+		t.Fatalf("getRedactedHTML() error = %v", err)
+	}
+
+	for _, secret := range []string{"secret-flow-token", "secret-canary", "secret-password", "typed-password", "secret-passcode", "secret-token", "secret-saml"} {
+		if strings.Contains(html, secret) {
+			t.Errorf("getRedactedHTML() contains %s:\n%s", secret, html)
+		}
+	}
+
+	for _, kept := range []string{"jane@example.com", `"urlPost":"/login"`} {
+		if !strings.Contains(html, kept) {
+			t.Errorf("getRedactedHTML() does not contain %s:\n%s", kept, html)
+		}
+	}
+}
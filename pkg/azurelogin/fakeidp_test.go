@@ -0,0 +1,592 @@
+package azurelogin
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/go-rod/rod/lib/launcher"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	testTenantID   = "00000000-0000-0000-0000-000000000000"
+	testAppIDUri   = "https://signin.aws.amazon.com/saml#test"
+	testUsername   = "jane@example.com"
+	testPassword   = "correct horse battery staple"
+	testCode       = "123456"
+	testOktaDomain = "okta.example.com"
+	testRoleArn    = "arn:aws:iam::123456789012:role/Developer"
+	testAdminArn   = "arn:aws:iam::123456789012:role/Admin"
+	testIdPArn     = "arn:aws:iam::123456789012:saml-provider/AzureAD"
+)
+
+// requireBrowser skips the test when there is no Chromium browser to run the login pages in, or fails it
+// when AZURE_LOGIN_REQUIRE_BROWSER is set, so that the login tests cannot be skipped silently in CI.
+func requireBrowser(t *testing.T) {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping browser test in short mode")
+	}
+
+	if _, has := launcher.LookPath(); !has {
+		if os.Getenv("AZURE_LOGIN_REQUIRE_BROWSER") != "" {
+			t.Fatal("no Chromium browser found and AZURE_LOGIN_REQUIRE_BROWSER is set")
+		}
+		t.Skip("no Chromium browser found")
+	}
+}
+
+// fakeIdP serves replicas of the Azure AD and Okta login pages, matching the selectors of the login states,
+// and ends the login by posting a signed SAML response to the AWS SAML endpoint, like Azure AD does.
+// Usernames of the Okta domain are federated to the Okta pages.
+type fakeIdP struct {
+	*httptest.Server
+
+	t        *testing.T
+	keyStore dsig.X509KeyStore
+	roles    []Role
+	acsURL   string
+	audience string
+	// sessionDuration is the SessionDuration attribute of the SAML responses in seconds, not set when empty
+	sessionDuration string
+
+	mu    sync.Mutex
+	pages []string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	idp := &fakeIdP{
+		t:        t,
+		keyStore: dsig.RandomKeyStoreForTest(),
+		roles: []Role{
+			{RoleArn: testRoleArn, PrincipalArn: testIdPArn},
+			{RoleArn: testAdminArn, PrincipalArn: testIdPArn},
+		},
+		acsURL:   AWS_SAML_ENDPOINT,
+		audience: testAppIDUri,
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{tenant}/saml2", func(w http.ResponseWriter, r *http.Request) {
+		idp.render(w, "azure username", azureUsernamePage, nil)
+	})
+
+	mux.HandleFunc("GET /{tenant}/federationmetadata/2007-06/federationmetadata.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://sts.windows.net/%s/">
+  <IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <KeyDescriptor use="signing">
+      <KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#"><X509Data><X509Certificate>%s</X509Certificate></X509Data></KeyInfo>
+    </KeyDescriptor>
+  </IDPSSODescriptor>
+</EntityDescriptor>`, testTenantID, base64.StdEncoding.EncodeToString(idp.certificate().Raw))
+	})
+
+	mux.HandleFunc("POST /azure/username", func(w http.ResponseWriter, r *http.Request) {
+		username := r.PostFormValue("loginfmt")
+
+		if strings.HasSuffix(username, "@"+testOktaDomain) {
+			http.Redirect(w, r, "/okta/signin", http.StatusFound)
+			return
+		}
+
+		idp.render(w, "azure password", azurePasswordPage, nil)
+	})
+
+	mux.HandleFunc("POST /azure/password", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("passwd") != testPassword {
+			idp.render(w, "azure password", azurePasswordPage, "Your account or password is incorrect.")
+			return
+		}
+
+		idp.render(w, "azure verification code", azureVerificationCodePage, nil)
+	})
+
+	mux.HandleFunc("POST /azure/otc", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("otc") != testCode {
+			idp.render(w, "azure verification code", azureVerificationCodePage, "You didn't enter the expected verification code.")
+			return
+		}
+
+		idp.render(w, "azure stay signed in", azureStaySignedInPage, nil)
+	})
+
+	mux.HandleFunc("POST /azure/kmsi", func(w http.ResponseWriter, r *http.Request) {
+		idp.postSAMLResponse(w)
+	})
+
+	mux.HandleFunc("GET /azure/error", func(w http.ResponseWriter, r *http.Request) {
+		idp.render(w, "azure error", azureErrorPage, "AADSTS53003: Access has been blocked by Conditional Access policies. The access policy does not allow token issuance.")
+	})
+
+	mux.HandleFunc("GET /azure/terms", func(w http.ResponseWriter, r *http.Request) {
+		idp.render(w, "terms of use", termsOfUsePage, nil)
+	})
+
+	mux.HandleFunc("POST /azure/terms", func(w http.ResponseWriter, r *http.Request) {
+		idp.postSAMLResponse(w)
+	})
+
+	mux.HandleFunc("GET /okta/signin", func(w http.ResponseWriter, r *http.Request) {
+		idp.render(w, "okta identifier", oktaIdentifierPage, nil)
+	})
+
+	mux.HandleFunc("POST /okta/identify", func(w http.ResponseWriter, r *http.Request) {
+		idp.render(w, "okta password", oktaPasswordPage, nil)
+	})
+
+	mux.HandleFunc("POST /okta/password", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("credentials.passcode") != testPassword {
+			idp.render(w, "okta password", oktaPasswordPage, "Password is incorrect")
+			return
+		}
+
+		idp.render(w, "okta select authenticator", oktaSelectAuthenticatorPage, nil)
+	})
+
+	mux.HandleFunc("GET /okta/push", func(w http.ResponseWriter, r *http.Request) {
+		idp.render(w, "okta push", oktaPushPage, nil)
+	})
+
+	mux.HandleFunc("GET /okta/push/send", func(w http.ResponseWriter, r *http.Request) {
+		// the push is approved right away
+		idp.postSAMLResponse(w)
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+// loginURL returns the URL of the first login page, like createLoginUrl does.
+func (idp *fakeIdP) loginURL() string {
+	return fmt.Sprintf("%s/%s/saml2?SAMLRequest=test", idp.URL, testTenantID)
+}
+
+// visited returns the names of the pages served so far.
+func (idp *fakeIdP) visited() []string {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	return append([]string{}, idp.pages...)
+}
+
+func (idp *fakeIdP) render(w http.ResponseWriter, name string, page *template.Template, data any) {
+	idp.mu.Lock()
+	idp.pages = append(idp.pages, name)
+	idp.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, data); err != nil {
+		idp.t.Errorf("fail to render %s page: %v", name, err)
+	}
+}
+
+// certificate returns the certificate the IdP signs the assertions with.
+func (idp *fakeIdP) certificate() *x509.Certificate {
+	idp.t.Helper()
+
+	_, der, err := idp.keyStore.GetKeyPair()
+	if err != nil {
+		idp.t.Fatalf("fail to get IdP key pair: %v", err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		idp.t.Fatalf("fail to parse IdP certificate: %v", err)
+	}
+
+	return certificate
+}
+
+// postSAMLResponse serves the page auto posting the SAML response to the AWS SAML endpoint.
+func (idp *fakeIdP) postSAMLResponse(w http.ResponseWriter) {
+	idp.render(w, "saml post", samlPostPage, map[string]string{
+		"ACS":          idp.acsURL,
+		"SAMLResponse": idp.samlResponse(time.Now()),
+	})
+}
+
+// samlResponse returns a base64 encoded SAML response issued at the time, with an assertion signed by the
+// key of the IdP and valid for 5 minutes, granting the roles of the IdP.
+func (idp *fakeIdP) samlResponse(issueInstant time.Time) string {
+	idp.t.Helper()
+
+	instant := issueInstant.UTC().Format(time.RFC3339)
+	notOnOrAfter := issueInstant.Add(5 * time.Minute).UTC().Format(time.RFC3339)
+	issuer := fmt.Sprintf("https://sts.windows.net/%s/", testTenantID)
+
+	doc := etree.NewDocument()
+
+	response := doc.CreateElement("samlp:Response")
+	response.CreateAttr("xmlns:samlp", "urn:oasis:names:tc:SAML:2.0:protocol")
+	response.CreateAttr("ID", "_response")
+	response.CreateAttr("Version", "2.0")
+	response.CreateAttr("IssueInstant", instant)
+	response.CreateAttr("Destination", idp.acsURL)
+
+	response.CreateElement("Issuer").CreateAttr("xmlns", "urn:oasis:names:tc:SAML:2.0:assertion")
+	response.SelectElement("Issuer").SetText(issuer)
+	response.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", "urn:oasis:names:tc:SAML:2.0:status:Success")
+
+	assertion := etree.NewElement("Assertion")
+	assertion.CreateAttr("xmlns", "urn:oasis:names:tc:SAML:2.0:assertion")
+	assertion.CreateAttr("ID", "_assertion")
+	assertion.CreateAttr("Version", "2.0")
+	assertion.CreateAttr("IssueInstant", instant)
+	assertion.CreateElement("Issuer").SetText(issuer)
+
+	subject := assertion.CreateElement("Subject")
+	subject.CreateElement("NameID").SetText(testUsername)
+	confirmation := subject.CreateElement("SubjectConfirmation")
+	confirmation.CreateAttr("Method", "urn:oasis:names:tc:SAML:2.0:cm:bearer")
+	confirmationData := confirmation.CreateElement("SubjectConfirmationData")
+	confirmationData.CreateAttr("NotOnOrAfter", notOnOrAfter)
+	confirmationData.CreateAttr("Recipient", idp.acsURL)
+
+	conditions := assertion.CreateElement("Conditions")
+	conditions.CreateAttr("NotBefore", instant)
+	conditions.CreateAttr("NotOnOrAfter", notOnOrAfter)
+	conditions.CreateElement("AudienceRestriction").CreateElement("Audience").SetText(idp.audience)
+
+	attributes := assertion.CreateElement("AttributeStatement")
+
+	roleAttribute := attributes.CreateElement("Attribute")
+	roleAttribute.CreateAttr("Name", ROLE_ATTRIBUTE)
+	for _, rl := range idp.roles {
+		roleAttribute.CreateElement("AttributeValue").SetText(rl.RoleArn + "," + rl.PrincipalArn)
+	}
+
+	sessionNameAttribute := attributes.CreateElement("Attribute")
+	sessionNameAttribute.CreateAttr("Name", ROLE_SESSION_NAME_ATTRIBUTE)
+	sessionNameAttribute.CreateElement("AttributeValue").SetText(testUsername)
+
+	if idp.sessionDuration != "" {
+		sessionDurationAttribute := attributes.CreateElement("Attribute")
+		sessionDurationAttribute.CreateAttr("Name", SESSION_DURATION_ATTRIBUTE)
+		sessionDurationAttribute.CreateElement("AttributeValue").SetText(idp.sessionDuration)
+	}
+
+	signingContext := dsig.NewDefaultSigningContext(idp.keyStore)
+	signingContext.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	signature, err := signingContext.ConstructSignature(assertion, true)
+	if err != nil {
+		idp.t.Fatalf("fail to sign assertion: %v", err)
+	}
+
+	// the signature goes right after the issuer
+	assertion.InsertChildAt(1, signature)
+
+	response.AddChild(assertion)
+
+	b, err := doc.WriteToBytes()
+	if err != nil {
+		idp.t.Fatalf("fail to write SAML response: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// fakeSTS answers the AssumeRoleWithSAML requests with fixed credentials, for the roles of the IdP.
+type fakeSTS struct {
+	*httptest.Server
+
+	t     *testing.T
+	roles []Role
+	// maxDurationSeconds is the maximum session duration of the roles, 12 hours when 0
+	maxDurationSeconds int
+
+	mu       sync.Mutex
+	requests []map[string]string
+}
+
+func newFakeSTS(t *testing.T, roles []Role) *fakeSTS {
+	s := &fakeSTS{t: t, roles: roles}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// isolateAWSConfig keeps the AWS SDK from reading the AWS config and credentials files of the user.
+func isolateAWSConfig(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", t.TempDir()+"/credentials")
+}
+
+// lastRequest returns the parameters of the last request, nil when there is none.
+func (s *fakeSTS) lastRequest() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func (s *fakeSTS) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.requests)
+}
+
+func (s *fakeSTS) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.writeError(w, "InvalidParameterValue", err.Error())
+		return
+	}
+
+	params := map[string]string{}
+	for k := range r.PostForm {
+		params[k] = r.PostForm.Get(k)
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, params)
+	s.mu.Unlock()
+
+	if params["Action"] != "AssumeRoleWithSAML" {
+		s.writeError(w, "InvalidAction", "Could not find operation "+params["Action"])
+		return
+	}
+
+	if _, err := ParseSAMLResponse(params["SAMLAssertion"]); err != nil {
+		s.writeError(w, "InvalidIdentityToken", "Invalid SAML assertion")
+		return
+	}
+
+	known := false
+	for _, rl := range s.roles {
+		if rl.RoleArn == params["RoleArn"] && rl.PrincipalArn == params["PrincipalArn"] {
+			known = true
+		}
+	}
+
+	if !known {
+		s.writeError(w, "AccessDenied", "Not authorized to perform sts:AssumeRoleWithSAML")
+		return
+	}
+
+	maxDurationSeconds := s.maxDurationSeconds
+	if maxDurationSeconds == 0 {
+		maxDurationSeconds = MAX_DURATION_HOURS * 60 * 60
+	}
+
+	if durationSeconds, err := strconv.Atoi(params["DurationSeconds"]); err == nil && durationSeconds > MAX_DURATION_HOURS*60*60 {
+		s.writeError(w, "ValidationError", fmt.Sprintf("1 validation error detected: Value '%d' at 'durationSeconds' failed to satisfy constraint: Member must have value less than or equal to %d", durationSeconds, MAX_DURATION_HOURS*60*60))
+		return
+	} else if err == nil && durationSeconds > maxDurationSeconds {
+		s.writeError(w, "ValidationError", "The requested DurationSeconds exceeds the MaxSessionDuration set for this role.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleWithSAMLResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithSAMLResult>
+    <Credentials>
+      <AccessKeyId>ASIAFAKEACCESSKEYID</AccessKeyId>
+      <SecretAccessKey>fake-secret-access-key</SecretAccessKey>
+      <SessionToken>fake-session-token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>%s/%s</Arn>
+      <AssumedRoleId>AROAFAKE:%s</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleWithSAMLResult>
+  <ResponseMetadata>
+    <RequestId>00000000-0000-0000-0000-000000000000</RequestId>
+  </ResponseMetadata>
+</AssumeRoleWithSAMLResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), params["RoleArn"], testUsername, testUsername)
+}
+
+func (s *fakeSTS) writeError(w http.ResponseWriter, code string, message string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusBadRequest)
+
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(message))
+
+	fmt.Fprintf(w, `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>%s</Code>
+    <Message>%s</Message>
+  </Error>
+  <RequestId>00000000-0000-0000-0000-000000000000</RequestId>
+</ErrorResponse>`, code, escaped.String())
+}
+
+// staticSecrets provides the passwords and verification codes by secret name.
+type staticSecrets map[string]string
+
+func (s staticSecrets) Password(name string) *string {
+	if v, ok := s[name]; ok {
+		return &v
+	}
+	return nil
+}
+
+func (s staticSecrets) VerificationCode(name string) *string {
+	return s.Password(name)
+}
+
+// failingPrompter fails the test when the login prompts for input.
+type failingPrompter struct {
+	t *testing.T
+}
+
+func (p failingPrompter) Input(message string, defaultValue string) (string, error) {
+	p.t.Errorf("unexpected prompt %q", message)
+	return "", fmt.Errorf("unexpected prompt %q", message)
+}
+
+func (p failingPrompter) Password(message string) (string, error) {
+	return p.Input(message, "")
+}
+
+func (p failingPrompter) Select(message string, options []string, defaultValue string) (string, error) {
+	return p.Input(message, defaultValue)
+}
+
+// answersPrompter answers the prompts with its answers, in order.
+type answersPrompter struct {
+	t       *testing.T
+	answers []string
+}
+
+func (p *answersPrompter) Input(message string, defaultValue string) (string, error) {
+	if len(p.answers) == 0 {
+		p.t.Errorf("unexpected prompt %q", message)
+		return "", fmt.Errorf("unexpected prompt %q", message)
+	}
+
+	answer := p.answers[0]
+	p.answers = p.answers[1:]
+	return answer, nil
+}
+
+func (p *answersPrompter) Password(message string) (string, error) {
+	return p.Input(message, "")
+}
+
+func (p *answersPrompter) Select(message string, options []string, defaultValue string) (string, error) {
+	return p.Input(message, defaultValue)
+}
+
+var azureUsernamePage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="/azure/username">
+  <div id="loginHeader">Sign in</div>
+  <input type="email" name="loginfmt" id="i0116">
+  <input type="submit" id="idSIButton9" value="Next">
+</form>
+</body></html>`))
+
+var azurePasswordPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="/azure/password">
+  <div id="loginHeader">Enter password</div>
+  {{if .}}<div id="passwordError">{{.}}</div>{{end}}
+  <input type="password" name="passwd" id="i0118">
+  <input type="submit" id="idSIButton9" value="Sign in">
+</form>
+</body></html>`))
+
+var azureVerificationCodePage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="/azure/otc">
+  <div id="idDiv_SAOTCC_Title">Enter code</div>
+  {{if .}}<div id="idSpan_SAOTCC_Error_OTC">{{.}}</div>{{end}}
+  <input type="tel" name="otc" id="idTxtBx_SAOTCC_OTC">
+  <input type="submit" id="idSubmit_SAOTCC_Continue" value="Verify">
+</form>
+</body></html>`))
+
+var azureStaySignedInPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="/azure/kmsi">
+  <div id="lightbox">Stay signed in?</div>
+  <input type="checkbox" name="DontShowAgain" id="KmsiCheckboxField">
+  <input type="submit" id="idBtn_Back" name="kmsi" value="No">
+  <input type="submit" id="idSIButton9" name="kmsi" value="Yes">
+</form>
+</body></html>`))
+
+var azureErrorPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<div id="service_exception_message">{{.}}</div>
+</body></html>`))
+
+var termsOfUsePage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="/azure/terms" id="termsOfUse">
+  <div>Accept the terms of use to continue.</div>
+  <button type="submit">Accept</button>
+</form>
+</body></html>`))
+
+var oktaIdentifierPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="/okta/identify" class="ion-form o-form">
+  <div class="o-form-info-container"></div>
+  <div class="o-form-error-container"></div>
+  <div class="o-form-fieldset-container">
+    <span class="okta-form-input-field input-fix"><input type="text" name="identifier"></span>
+  </div>
+  <input type="submit" class="button button-primary" value="Next">
+</form>
+</body></html>`))
+
+var oktaPasswordPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" action="/okta/password" class="ion-form o-form">
+  <div class="o-form-info-container"></div>
+  {{if .}}<div class="o-form-error-container o-form-has-errors" data-se="o-form-error-container"><div><div class="okta-form-infobox-error infobox infobox-error" role="alert"><span class="icon error-16"></span><p>{{.}}</p></div></div></div>{{else}}<div class="o-form-error-container"></div>{{end}}
+  <div class="o-form-fieldset-container">
+    <span class="okta-form-input-field input-fix"><input type="password" name="credentials.passcode"></span>
+  </div>
+  <input type="submit" class="button button-primary" value="Verify">
+</form>
+</body></html>`))
+
+var oktaSelectAuthenticatorPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<div class="authenticator-list">
+  <div class="authenticator-row" data-se="okta_verify-push"><a class="button select-factor" href="/okta/push">Select</a></div>
+</div>
+</body></html>`))
+
+var oktaPushPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<a class="button button-primary send-push" href="/okta/push/send">Send push</a>
+</body></html>`))
+
+var samlPostPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><body>
+<form method="post" name="hiddenform" action="{{.ACS}}">
+  <input type="hidden" name="SAMLResponse" value="{{.SAMLResponse}}">
+  <noscript><p>Script is disabled. Click Submit to continue.</p><input type="submit" value="Submit"></noscript>
+</form>
+<script>window.setTimeout(function () { document.forms[0].submit(); }, 0);</script>
+</body></html>`))
@@ -0,0 +1,616 @@
+package azurelogin
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/google/uuid"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	AZURE_AD_SSO          = "autologon.microsoftazuread-sso.com"
+	AZURE_AUTHORITY_HOST  = "login.microsoftonline.com"
+	AWS_SAML_ENDPOINT     = "https://signin.aws.amazon.com/saml"
+	AWS_CN_SAML_ENDPOINT  = "https://signin.amazonaws.cn/saml"
+	AWS_GOV_SAML_ENDPOINT = "https://signin.amazonaws-us-gov.com/saml"
+	OKTA_SELECT_FAST_PASS = "OKTA SELECT FastPass"
+	OKTA_SELECT_PUSH_FORM = "OKTA SELECT PUSH Form"
+	OKTA_DO_PUSH_FORM     = "OKTA DO PUSH Form"
+
+	WIDTH  = 425
+	HEIGHT = 550
+)
+
+// Options are the settings of a login.
+type Options struct {
+	// ProfileName is the name of the profile, whose secrets are read from the secret store
+	ProfileName string
+	// Profile is the configuration of the profile, see ConfigStore.LoadProfile
+	Profile ProfileConfig
+	// NoPrompt accepts the default choices instead of prompting for input
+	NoPrompt bool
+	// Gui performs the login through the Azure login page instead of the CLI
+	Gui bool
+	// DisableLeakless disables the leakless helper killing the browser when the process dies
+	DisableLeakless bool
+	// FastPass uses Okta FastPass verification
+	FastPass bool
+	// NoVerifySSL disables the SSL peer verification of the connections to AWS
+	NoVerifySSL bool
+	// DiagnosticsDir is the directory where diagnostics are written when the login fails, none when empty
+	DiagnosticsDir string
+	// LoginTimeout limits each login to Azure AD, see GetSAMLResponse, none when 0
+	LoginTimeout time.Duration
+	// Prompter asks for the values filled in the login pages, the role and the session duration, SurveyPrompter when nil
+	Prompter Prompter
+	// Secrets provides the passwords and verification codes, read from the profile and the secret store when nil
+	Secrets SecretSource
+	// Output receives the messages of the login, os.Stdout when nil
+	Output io.Writer
+}
+
+// prompter returns the prompter of the options, SurveyPrompter prompting on the output when it is not set.
+func (o Options) prompter() Prompter {
+	if o.Prompter != nil {
+		return o.Prompter
+	}
+
+	if f, ok := o.Output.(*os.File); ok {
+		return SurveyPrompter{Output: f}
+	}
+	return SurveyPrompter{}
+}
+
+// output returns the output of the options, os.Stdout when it is not set.
+func (o Options) output() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// Login logs in to Azure AD, asks for the role and session duration unless the profile has defaults
+// and NoPrompt is set, and assumes the role. The context can cancel the login, and the LoginTimeout of the options
+// limits the login to Azure AD.
+func Login(ctx context.Context, opts Options) (*Credentials, error) {
+	saml, response, err := GetSAMLResponse(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := response.Roles()
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse roles: %w", err)
+	}
+
+	session, err := response.SessionAttributes()
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse session attributes: %w", err)
+	}
+
+	rl, durationHours, err := AskUserForRoleAndDuration(roles, session, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return AssumeRole(ctx, saml, rl, durationHours, opts)
+}
+
+// GetSAMLResponse logs in to Azure AD and returns the base64 encoded SAML response posted to AWS, and its parsed
+// content. When azure_verify_saml is set, the response is verified and its content is read from the signed XML only.
+// The login is limited by the LoginTimeout of the options.
+func GetSAMLResponse(ctx context.Context, opts Options) (string, *SAMLResponse, error) {
+	profile := opts.Profile
+
+	if opts.LoginTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.LoginTimeout)
+		defer cancel()
+	}
+
+	if err := profile.ValidateAzureCloud(); err != nil {
+		return "", nil, err
+	}
+
+	assertionConsumerServiceURL := profile.AssertionConsumerServiceURL()
+	loginUrl := createLoginUrl(profile.AuthorityURL(), profile.AzureAppIDUri, profile.AzureTenantID, assertionConsumerServiceURL)
+
+	userDataDir := ""
+	if profile.AzureDefaultRememberMe {
+		userDataDir = GetChromiumUserDataDir(profile.AzureTenantID, profile.AzureDefaultUsername)
+	}
+
+	session := &LoginSession{
+		ProfileName: opts.ProfileName,
+		Profile:     profile,
+		NoPrompt:    opts.NoPrompt,
+		Gui:         opts.Gui,
+		FastPass:    opts.FastPass,
+		Prompter:    opts.prompter(),
+		Secrets:     opts.Secrets,
+		Output:      opts.output(),
+	}
+
+	if session.Secrets == nil {
+		session.Secrets = newProfileSecrets(opts.ProfileName, profile, opts.NoPrompt, session.Output)
+	}
+
+	saml, err := performLogin(ctx, loginUrl, assertionConsumerServiceURL, session, opts.DisableLeakless, userDataDir, opts.DiagnosticsDir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !profile.AzureVerifySAML {
+		response, err := ParseSAMLResponse(saml)
+		if err != nil {
+			return "", nil, fmt.Errorf("fail to parse SAML response: %w", err)
+		}
+
+		return saml, response, nil
+	}
+
+	certificates, err := profile.SAMLCertificates(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("fail to get SAML signing certificates: %w", err)
+	}
+
+	response, err := VerifySAMLResponse(saml, SAMLVerification{
+		Certificates: certificates,
+		Audience:     profile.AzureAppIDUri,
+		Destination:  assertionConsumerServiceURL,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return saml, response, nil
+}
+
+// GetAssertionConsumerServiceURL returns the AWS SAML endpoint of the partition of the region.
+func GetAssertionConsumerServiceURL(region *string) string {
+	switch GetAWSPartition(region) {
+	case AWS_GOV_PARTITION:
+		return AWS_GOV_SAML_ENDPOINT
+	case AWS_CN_PARTITION:
+		return AWS_CN_SAML_ENDPOINT
+	default:
+		return AWS_SAML_ENDPOINT
+	}
+}
+
+// AssertionConsumerServiceURL returns the AWS SAML endpoint the SAML response is posted to, the one of the
+// partition of the region unless aws_saml_acs_url is set.
+func (p ProfileConfig) AssertionConsumerServiceURL() string {
+	if p.AwsSamlAcsUrl != nil && *p.AwsSamlAcsUrl != "" {
+		return *p.AwsSamlAcsUrl
+	}
+
+	return GetAssertionConsumerServiceURL(p.Region)
+}
+
+// AuthorityURL returns the URL of the Azure AD authority the login starts at, the one of the Azure cloud
+// unless azure_authority_host is set. The host can be given as a URL to use another scheme or a port.
+func (p ProfileConfig) AuthorityURL() string {
+	host := p.AzureCloud().AuthorityHost
+
+	if p.AzureAuthorityHost != nil && *p.AzureAuthorityHost != "" {
+		host = *p.AzureAuthorityHost
+	}
+
+	if strings.Contains(host, "://") {
+		return strings.TrimSuffix(host, "/")
+	}
+
+	return "https://" + strings.TrimSuffix(host, "/")
+}
+
+func createLoginUrl(authorityURL string, appIDUri string, tenantID string, assertionConsumerServiceURL string) string {
+	id := uuid.NewString()
+
+	samlRequest := fmt.Sprintf(`
+	<samlp:AuthnRequest xmlns="urn:oasis:names:tc:SAML:2.0:metadata" ID="id%s" Version="2.0" IssueInstant="%s" IsPassive="false" AssertionConsumerServiceURL="%s" xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">
+		<Issuer xmlns="urn:oasis:names:tc:SAML:2.0:assertion">%s</Issuer>
+		<samlp:NameIDPolicy Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"></samlp:NameIDPolicy>
+	</samlp:AuthnRequest>
+	`, id, time.Now().Format(time.RFC3339), assertionConsumerServiceURL, appIDUri)
+
+	var buffer bytes.Buffer
+
+	flateWriter, _ := flate.NewWriter(&buffer, -1)
+
+	flateWriter.Write([]byte(samlRequest))
+	flateWriter.Flush()
+	flateWriter.Close()
+
+	samlBase64 := base64.StdEncoding.EncodeToString(buffer.Bytes())
+
+	return fmt.Sprintf("%s/%s/saml2?SAMLRequest=%s", authorityURL, tenantID, url.QueryEscape(samlBase64))
+}
+
+func performLogin(ctx context.Context, urlString string, assertionConsumerServiceURL string, session *LoginSession, disableLeakless bool, userDataDir string, diagnosticsDir string) (samlResponse string, err error) {
+	l := launcher.New().Headless(!session.Gui)
+
+	l.Leakless(!disableLeakless)
+
+	// let the Azure AD Seamless SSO authenticate with Kerberos
+	ssoHost := session.Profile.AzureCloud().SSOHost
+	l.Set("auth-server-allowlist", ssoHost).Set("auth-negotiate-delegate-allowlist", ssoHost)
+
+	defer l.Kill()
+
+	if userDataDir != "" {
+		if err := os.MkdirAll(userDataDir, 0700); err != nil {
+			return "", fmt.Errorf("fail to create chromium profile directory: %w", err)
+		}
+		l.UserDataDir(userDataDir)
+	}
+
+	u, err := l.Launch()
+	if err != nil {
+		return "", fmt.Errorf("fail to launch browser: %w", err)
+	}
+
+	browser := rod.New().ControlURL(u)
+	if err := browser.Connect(); err != nil {
+		return "", fmt.Errorf("fail to connect to browser: %w", err)
+	}
+
+	defer browser.Close()
+
+	router := browser.HijackRequests()
+	defer router.Stop()
+
+	// the body of the form posted to the AWS SAML endpoint
+	samlRequestChan := make(chan string, 1)
+
+	err = router.Add(assertionConsumerServiceURL+"*", "", func(ctx *rod.Hijack) {
+		reqURL := ctx.Request.URL().String()
+
+		if reqURL == assertionConsumerServiceURL {
+
+			samlRequestChan <- ctx.Request.Body()
+
+			ctx.Response.Fail(proto.NetworkErrorReasonInternetDisconnected)
+		} else {
+			ctx.ContinueRequest(&proto.FetchContinueRequest{})
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("fail to intercept the AWS SAML endpoint: %w", err)
+	}
+
+	err = router.Add("https://*okta*", "", func(ctx *rod.Hijack) {
+		reqURL, error := url.Parse(ctx.Request.URL().String())
+		if error == nil {
+			values := reqURL.Query()
+			if values.Has("username") {
+				values.Del("username")
+				reqURL.RawQuery = values.Encode()
+
+				ctx.ContinueRequest(&proto.FetchContinueRequest{URL: reqURL.String()})
+				return
+			}
+		}
+		ctx.ContinueRequest(&proto.FetchContinueRequest{})
+	})
+	if err != nil {
+		return "", fmt.Errorf("fail to intercept the Okta requests: %w", err)
+	}
+
+	go router.Run()
+
+	page, err := browser.Page(proto.TargetCreateTarget{})
+	if err != nil {
+		return "", fmt.Errorf("fail to open browser page: %w", err)
+	}
+
+	diagnostics := newLoginDiagnostics(diagnosticsDir, session.Output)
+
+	// the page operations fail once the context is done, and the Must* ones panic, report them as errors
+	defer func() {
+		if r := recover(); r != nil {
+			samlResponse = ""
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+
+		if ctx.Err() != nil && samlResponse == "" {
+			err = NewInterruptedLoginError(ctx, page)
+		}
+
+		if err != nil {
+			diagnostics.write(page, err)
+		}
+	}()
+
+	page = page.Context(ctx)
+	session.Page = page
+
+	diagnosticsCtx, stopDiagnostics := context.WithCancel(ctx)
+	defer stopDiagnostics()
+
+	diagnostics.recordNetwork(diagnosticsCtx, page)
+
+	wait := page.WaitNavigation(proto.PageLifecycleEventNameDOMContentLoaded)
+	page.MustNavigate(urlString)
+	wait()
+
+	if userDataDir != "" {
+		// A remembered session redirects straight to the SAML endpoint, without showing any login page
+		select {
+		case body := <-samlRequestChan:
+			fmt.Fprintln(session.Output, "Reusing remembered Azure session")
+			return getSamlResponseFromBody(body)
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return "", NewInterruptedLoginError(ctx, page)
+		}
+	}
+
+	if session.Gui && !session.NoPrompt {
+		select {
+		case body := <-samlRequestChan:
+			return getSamlResponseFromBody(body)
+		case <-ctx.Done():
+			return "", NewInterruptedLoginError(ctx, page)
+		}
+	}
+
+	for {
+		if err := detectLoginError(page, session.NoPrompt); err != nil {
+			return "", err
+		}
+
+		for _, st := range getStates() {
+			select {
+			case body := <-samlRequestChan:
+				return getSamlResponseFromBody(body)
+			case <-ctx.Done():
+				return "", NewInterruptedLoginError(ctx, page)
+			default:
+			}
+
+			if (session.FastPass && (st.Name == OKTA_SELECT_PUSH_FORM || st.Name == OKTA_DO_PUSH_FORM)) || (!session.FastPass && st.Name == OKTA_SELECT_FAST_PASS) {
+				continue
+			}
+
+			el, err := page.Sleeper(rod.NotFoundSleeper).Element(st.Selector)
+
+			if err == nil {
+				diagnostics.addState(st.Name)
+				if err := st.Handler.Handle(session, el); err != nil {
+					return "", err
+				}
+
+				// the page is only handled by the first state matching it
+				break
+			}
+		}
+	}
+}
+
+// isDurationValidationError tells if STS rejected the requested session duration, longer than the maximum
+// session duration of the role.
+func isDurationValidationError(err error) bool {
+	var apiErr smithy.APIError
+
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationError" && strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "durationseconds")
+}
+
+var maxDurationSecondsRegexp = regexp.MustCompile(`less than or equal to (\d+)`)
+
+// getMaxDurationHours returns the longest session duration known to be allowed once STS rejected the requested
+// one: the maximum given by the STS error, or the session duration of the SAML response when shorter than
+// requested. It returns 0 when the maximum is unknown.
+func getMaxDurationHours(err error, assertion string, requestedHours int32) int32 {
+	if match := maxDurationSecondsRegexp.FindStringSubmatch(err.Error()); match != nil {
+		if seconds, err := strconv.Atoi(match[1]); err == nil && seconds >= 60*60 {
+			return int32(seconds / (60 * 60))
+		}
+	}
+
+	if session, err := ParseSessionAttributes(assertion); err == nil {
+		if h := session.MaxDurationHours(); h > 0 && h < requestedHours {
+			return h
+		}
+	}
+
+	return 0
+}
+
+// getSamlResponseFromBody returns the SAMLResponse of the form posted to the AWS SAML endpoint.
+func getSamlResponseFromBody(body string) (string, error) {
+	val, err := url.ParseQuery(body)
+	if err != nil {
+		return "", fmt.Errorf("fail to parse saml endpoint request: %w", err)
+	}
+
+	return val.Get("SAMLResponse"), nil
+}
+
+// ErrNoRoles is returned when the SAML response has no role to assume.
+var ErrNoRoles = errors.New("no roles found in SAML response")
+
+// AskUserForRoleAndDuration asks which role to assume and for how long with the prompter of the options, unless
+// there is only one role or NoPrompt is set and the profile has defaults. The session duration of the SAML response
+// is the default and longest duration.
+func AskUserForRoleAndDuration(roles []Role, session SessionAttributes, opts Options) (r Role, durationHours int32, err error) {
+	defaultRoleArn := opts.Profile.AzureDefaultRoleArn
+
+	if len(roles) == 0 {
+		return r, 0, ErrNoRoles
+	} else if len(roles) == 1 {
+		r = roles[0]
+	} else {
+		if opts.NoPrompt && defaultRoleArn != "" {
+			for _, rl := range roles {
+				if rl.RoleArn == defaultRoleArn {
+					r = rl
+					break
+				}
+			}
+		}
+
+		if (Role{} == r) {
+			var options []string
+			defaultOption := ""
+
+			for _, rl := range roles {
+				options = append(options, rl.RoleArn)
+				if rl.RoleArn == defaultRoleArn {
+					defaultOption = defaultRoleArn
+				}
+			}
+
+			rArn, err := opts.prompter().Select("Role:", options, defaultOption)
+			if err != nil {
+				return r, 0, fmt.Errorf("fail to get role: %w", err)
+			}
+
+			for _, rl := range roles {
+				if rl.RoleArn == rArn {
+					r = rl
+					break
+				}
+			}
+		}
+	}
+
+	durationHours, err = AskUserForDuration(session, opts)
+	return
+}
+
+// durationAttempts is the number of times an invalid session duration is asked again
+const durationAttempts = 3
+
+// AskUserForDuration asks for the session duration in hours with the prompter of the options, unless NoPrompt
+// is set and the profile has a default. The session duration of the SAML response, when set, is the default
+// and longest duration.
+func AskUserForDuration(session SessionAttributes, opts Options) (int32, error) {
+	defaultDurationHours := opts.Profile.AzureDefaultDurationHours
+	maxDurationHours := int32(MAX_DURATION_HOURS)
+
+	if h := session.MaxDurationHours(); h > 0 {
+		maxDurationHours = h
+		if defaultDurationHours == "" {
+			defaultDurationHours = strconv.Itoa(int(h))
+		}
+	}
+
+	if n, err := strconv.ParseInt(defaultDurationHours, 10, 32); err == nil && n > int64(maxDurationHours) {
+		defaultDurationHours = strconv.Itoa(int(maxDurationHours))
+	}
+
+	if opts.NoPrompt && defaultDurationHours != "" {
+		durationHours, _ := strconv.ParseInt(defaultDurationHours, 10, 32)
+		return int32(durationHours), nil
+	}
+
+	if session.RoleSessionName != "" {
+		fmt.Fprintf(opts.output(), "Role session name: %s\n", session.RoleSessionName)
+	}
+
+	for attempt := 1; ; attempt++ {
+		answer, err := opts.prompter().Input(fmt.Sprintf("Session Duration Hours (up to %d):", maxDurationHours), defaultDurationHours)
+		if err != nil {
+			return 0, fmt.Errorf("fail to get session duration: %w", err)
+		}
+
+		if n, err := strconv.ParseInt(strings.TrimSpace(answer), 10, 32); err == nil && n > 0 && n <= int64(maxDurationHours) {
+			return int32(n), nil
+		}
+
+		if attempt == durationAttempts {
+			return 0, fmt.Errorf("invalid session duration %q, duration hours must be between 1 and %d", answer, maxDurationHours)
+		}
+
+		fmt.Fprintf(opts.output(), "Duration hours must be between 1 and %d\n", maxDurationHours)
+	}
+}
+
+// AssumeRole assumes the role with the SAML response for the duration, using the STS endpoint and the region
+// of the profile of the options.
+func AssumeRole(
+	ctx context.Context,
+	assertion string,
+	role Role,
+	durationHours int32,
+	opts Options) (*Credentials, error) {
+
+	durationSeconds := durationHours * 60 * 60
+	stsInput := sts.AssumeRoleWithSAMLInput{
+		PrincipalArn:    &role.PrincipalArn,
+		RoleArn:         &role.RoleArn,
+		SAMLAssertion:   &assertion,
+		DurationSeconds: &durationSeconds,
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get AWS config: %w", err)
+	}
+
+	if opts.Profile.Region != nil {
+		cfg.Region = *opts.Profile.Region
+	}
+
+	stsClient := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if opts.Profile.AwsStsEndpoint != nil && *opts.Profile.AwsStsEndpoint != "" {
+			o.BaseEndpoint = opts.Profile.AwsStsEndpoint
+		}
+	})
+
+	stsResult, err := stsClient.AssumeRoleWithSAML(ctx, &stsInput)
+
+	// the role allows shorter sessions than requested, retry from the longest one known to be allowed,
+	// or one hour shorter each time until STS accepts it
+	allowedHours := durationHours
+	for isDurationValidationError(err) && allowedHours > 1 {
+		if h := getMaxDurationHours(err, assertion, allowedHours); h > 0 && h < allowedHours {
+			allowedHours = h
+		} else {
+			allowedHours--
+		}
+
+		allowedSeconds := allowedHours * 60 * 60
+		stsInput.DurationSeconds = &allowedSeconds
+
+		stsResult, err = stsClient.AssumeRoleWithSAML(ctx, &stsInput)
+	}
+
+	if err == nil && allowedHours < durationHours {
+		fmt.Fprintf(opts.output(), "The role %s does not allow %d hour sessions, assumed it for %d hour(s)\n", role.RoleArn, durationHours, allowedHours)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("fail to assume role: %w", err)
+	}
+
+	return &Credentials{
+		AwsAccessKeyID:     *stsResult.Credentials.AccessKeyId,
+		AwsSecretAccessKey: *stsResult.Credentials.SecretAccessKey,
+		AwsSessionToken:    *stsResult.Credentials.SessionToken,
+		AwsExpiration:      (*stsResult.Credentials.Expiration).UTC().Format(TimeFormat),
+	}, nil
+}
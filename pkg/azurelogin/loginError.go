@@ -0,0 +1,182 @@
+package azurelogin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+)
+
+// Exit codes of the login errors, so that wrapper scripts can react to them (2 is used for usage errors)
+const (
+	EXIT_LOGIN_FAILED        = 1
+	EXIT_INVALID_CREDENTIALS = 3
+	EXIT_MFA_FAILED          = 4
+	EXIT_CONFIGURATION_ERROR = 5
+	EXIT_ACCESS_BLOCKED      = 6
+	EXIT_TIMEOUT             = 7
+	EXIT_INTERRUPTED         = 130
+)
+
+// LoginError is an error reported by Azure AD (with its AADSTS code) or Okta during the login, or a SAML response
+// failing verification (with the failed condition as code).
+type LoginError struct {
+	Code        string
+	Message     string
+	Explanation string
+	Hint        string
+	ExitCode    int
+}
+
+func (e *LoginError) Error() string {
+	msg := e.Explanation
+	if e.Code != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Code)
+	}
+	if e.Message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+	return msg
+}
+
+type loginErrorDescription struct {
+	explanation string
+	hint        string
+	exitCode    int
+}
+
+var aadstsErrors = map[string]loginErrorDescription{
+	"50034":  {"The user account does not exist in the tenant", "Check the username, or the azure_default_username of the profile.", EXIT_INVALID_CREDENTIALS},
+	"50126":  {"Invalid username or password", "Check your password, or update the saved one with -configure.", EXIT_INVALID_CREDENTIALS},
+	"50053":  {"The account is locked", "Wait for the account to be unlocked, or contact your Azure AD administrator.", EXIT_INVALID_CREDENTIALS},
+	"50055":  {"The password is expired", "Change your password in the Azure portal, then update the saved one.", EXIT_INVALID_CREDENTIALS},
+	"50057":  {"The user account is disabled", "Contact your Azure AD administrator.", EXIT_ACCESS_BLOCKED},
+	"50074":  {"Strong authentication is required", "Run the login without -no-prompt to complete the MFA.", EXIT_MFA_FAILED},
+	"50076":  {"Multi-factor authentication is required", "Run the login without -no-prompt to complete the MFA.", EXIT_MFA_FAILED},
+	"50079":  {"Multi-factor authentication registration is required", "Register a verification method at https://aka.ms/mfasetup.", EXIT_MFA_FAILED},
+	"500121": {"The strong authentication request failed", "Approve the sign in request or enter the verification code in time, then try again.", EXIT_MFA_FAILED},
+	"50158":  {"An external security challenge was not satisfied", "Run the login without -no-prompt to complete the challenge.", EXIT_MFA_FAILED},
+	"50020":  {"The user account is from an identity provider that does not exist in the tenant", "Check the azure_tenant_id of the profile.", EXIT_CONFIGURATION_ERROR},
+	"50105":  {"The user is not assigned to the application", "Ask your Azure AD administrator to assign you to the AWS application.", EXIT_CONFIGURATION_ERROR},
+	"50011":  {"The reply URL does not match the ones configured for the application", "Check the region of the profile, the AWS SAML endpoint depends on it.", EXIT_CONFIGURATION_ERROR},
+	"90002":  {"The tenant was not found", "Check the azure_tenant_id of the profile.", EXIT_CONFIGURATION_ERROR},
+	"700016": {"The application was not found in the tenant", "Check the azure_app_id_uri and azure_tenant_id of the profile.", EXIT_CONFIGURATION_ERROR},
+	"53000":  {"Conditional Access requires a compliant device", "Sign in from a compliant device, or contact your Azure AD administrator.", EXIT_ACCESS_BLOCKED},
+	"53001":  {"Conditional Access requires a domain joined device", "Sign in from a domain joined device, or contact your Azure AD administrator.", EXIT_ACCESS_BLOCKED},
+	"53003":  {"Access has been blocked by Conditional Access policies", "Contact your Azure AD administrator.", EXIT_ACCESS_BLOCKED},
+	"530032": {"Access has been blocked by a security policy", "Contact your Azure AD administrator.", EXIT_ACCESS_BLOCKED},
+}
+
+var aadstsCodeRegexp = regexp.MustCompile(`AADSTS(\d+)`)
+
+// newAadstsError returns the login error of the AADSTS error message.
+func newAadstsError(message string) *LoginError {
+	code := ""
+	description := loginErrorDescription{"Azure AD returned an error", "Run again with -mode debug to see the login page.", EXIT_LOGIN_FAILED}
+
+	if match := aadstsCodeRegexp.FindStringSubmatch(message); match != nil {
+		code = match[0]
+		if d, ok := aadstsErrors[match[1]]; ok {
+			description = d
+		}
+	}
+
+	return &LoginError{
+		Code:        code,
+		Message:     message,
+		Explanation: description.explanation,
+		Hint:        description.hint,
+		ExitCode:    description.exitCode,
+	}
+}
+
+// detectLoginError looks for an Azure AD or Okta error on the page. The errors shown in the
+// login forms are only reported without prompting, as the user can fix them otherwise.
+func detectLoginError(pg *rod.Page, noPrompt bool) *LoginError {
+	if t := getElementText(pg, `#service_exception_message,#ServiceExceptionMessage`); t != "" {
+		return newAadstsError(t)
+	}
+
+	// the form errors of Okta are shown in an infobox too, inside the error container of the form
+	if t := getElementText(pg, `.okta-form-infobox-error:not(.o-form-error-container .okta-form-infobox-error),div.error-content`); t != "" {
+		return &LoginError{Message: t, Explanation: "Okta returned an error", Hint: "Run again with -mode debug to see the login page.", ExitCode: EXIT_LOGIN_FAILED}
+	}
+
+	if !noPrompt {
+		return nil
+	}
+
+	if t := getElementText(pg, `#passwordError`); t != "" {
+		err := newAadstsError("AADSTS50126")
+		err.Message = t
+		return err
+	}
+
+	if t := getElementText(pg, `#usernameError`); t != "" {
+		err := newAadstsError("AADSTS50034")
+		err.Message = t
+		return err
+	}
+
+	if t := getElementText(pg, `div.o-form-error-container.o-form-has-errors`); t != "" {
+		return &LoginError{Message: t, Explanation: "Okta rejected the login", Hint: "Check your Okta username and password.", ExitCode: EXIT_INVALID_CREDENTIALS}
+	}
+
+	return nil
+}
+
+// NewInterruptedLoginError returns the login error of a login stopped by the context, telling on which page
+// it was stuck when the timeout was reached.
+func NewInterruptedLoginError(ctx context.Context, pg *rod.Page) *LoginError {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &LoginError{Explanation: "The login was interrupted", ExitCode: EXIT_INTERRUPTED}
+	}
+
+	err := &LoginError{
+		Explanation: "The login timed out",
+		Hint:        "Run again with -mode debug to see the login page, or increase -timeout.",
+		ExitCode:    EXIT_TIMEOUT,
+	}
+
+	if pg != nil {
+		// the page context is done, use a new one to get where the login was stuck
+		if info, e := pg.Context(context.Background()).Timeout(5 * time.Second).Info(); e == nil {
+			err.Message = fmt.Sprintf("stuck on page %q (%s)", info.Title, info.URL)
+		}
+	}
+
+	return err
+}
+
+func getElementText(pg *rod.Page, selector string) string {
+	el, err := pg.Sleeper(rod.NotFoundSleeper).Element(selector)
+	if err != nil || el == nil {
+		return ""
+	}
+
+	if visible, err := el.Visible(); err != nil || !visible {
+		return ""
+	}
+
+	t, _ := el.Text()
+	return strings.TrimSpace(t)
+}
+
+// ExitCode returns the exit code of the error, telling why the login failed.
+func ExitCode(err error) int {
+	var lErr *LoginError
+	if errors.As(err, &lErr) {
+		return lErr.ExitCode
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return EXIT_TIMEOUT
+	}
+	if errors.Is(err, context.Canceled) {
+		return EXIT_INTERRUPTED
+	}
+	return EXIT_LOGIN_FAILED
+}
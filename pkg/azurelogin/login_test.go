@@ -0,0 +1,699 @@
+package azurelogin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/defaults"
+)
+
+func TestParseRoles(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	roles, err := ParseRoles(idp.samlResponse(time.Now()))
+	if err != nil {
+		t.Fatalf("ParseRoles() error = %v", err)
+	}
+
+	if !slices.Equal(roles, idp.roles) {
+		t.Errorf("ParseRoles() = %v, want %v", roles, idp.roles)
+	}
+}
+
+func TestParseRolesWrappedAssertion(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	saml := editSAMLResponse(t, idp.samlResponse(time.Now()), func(s string) string {
+		return strings.Replace(s, "</samlp:Response>", wrappedAssertion+"</samlp:Response>", 1)
+	})
+
+	if roles, err := ParseRoles(saml); err == nil {
+		t.Errorf("ParseRoles() of a response with two assertions = %v, want an error", roles)
+	}
+}
+
+func TestIsSAMLResponseValid(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	if !IsSAMLResponseValid(idp.samlResponse(time.Now())) {
+		t.Error("IsSAMLResponseValid() = false for a new response, want true")
+	}
+
+	if IsSAMLResponseValid(idp.samlResponse(time.Now().Add(-10 * time.Minute))) {
+		t.Error("IsSAMLResponseValid() = true for an expired response, want false")
+	}
+
+	if IsSAMLResponseValid("not a SAML response") {
+		t.Error("IsSAMLResponseValid() = true for an invalid response, want false")
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	idp := newFakeIdP(t)
+	sts := newFakeSTS(t, idp.roles)
+	isolateAWSConfig(t)
+
+	saml := idp.samlResponse(time.Now())
+
+	credentials, err := AssumeRole(context.Background(), saml, idp.roles[0], 2, Options{Profile: ProfileConfig{AwsStsEndpoint: &sts.URL}, Output: io.Discard})
+	if err != nil {
+		t.Fatalf("AssumeRole() error = %v", err)
+	}
+
+	if credentials.AwsAccessKeyID != "ASIAFAKEACCESSKEYID" || credentials.AwsSecretAccessKey != "fake-secret-access-key" || credentials.AwsSessionToken != "fake-session-token" {
+		t.Errorf("AssumeRole() = %+v, want the credentials of the fake STS", credentials)
+	}
+
+	req := sts.lastRequest()
+	if req["RoleArn"] != testRoleArn || req["PrincipalArn"] != testIdPArn || req["SAMLAssertion"] != saml || req["DurationSeconds"] != "7200" {
+		t.Errorf("AssumeRoleWithSAML request = %v, want role %s, principal %s and 7200 seconds", req, testRoleArn, testIdPArn)
+	}
+
+	_, err = AssumeRole(context.Background(), saml, Role{RoleArn: "arn:aws:iam::123456789012:role/Unknown", PrincipalArn: testIdPArn}, 1, Options{Profile: ProfileConfig{AwsStsEndpoint: &sts.URL}, Output: io.Discard})
+	if err == nil {
+		t.Error("AssumeRole() of a role not granted by the assertion succeeded, want an error")
+	}
+}
+
+func TestAssumeRoleShorterMaxSessionDuration(t *testing.T) {
+	tests := []struct {
+		name            string
+		sessionDuration string
+		durationHours   int32
+		want            string
+		requests        int
+	}{
+		{name: "maximum of the STS error", durationHours: 13, want: "43200", requests: 2},
+		{name: "session duration of the SAML response", sessionDuration: "14400", durationHours: 12, want: "14400", requests: 2},
+		{name: "unknown maximum", durationHours: 12, want: "14400", requests: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t)
+			idp.sessionDuration = tt.sessionDuration
+			sts := newFakeSTS(t, idp.roles)
+			if tt.durationHours <= MAX_DURATION_HOURS {
+				sts.maxDurationSeconds = 4 * 60 * 60
+			}
+			isolateAWSConfig(t)
+
+			if _, err := AssumeRole(context.Background(), idp.samlResponse(time.Now()), idp.roles[0], tt.durationHours, Options{Profile: ProfileConfig{AwsStsEndpoint: &sts.URL}, Output: io.Discard}); err != nil {
+				t.Fatalf("AssumeRole() error = %v", err)
+			}
+
+			if n := sts.requestCount(); n != tt.requests {
+				t.Errorf("AssumeRoleWithSAML called %d times, want %d", n, tt.requests)
+			}
+
+			if req := sts.lastRequest(); req["DurationSeconds"] != tt.want {
+				t.Errorf("AssumeRoleWithSAML DurationSeconds = %s, want %s", req["DurationSeconds"], tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSessionAttributes(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.sessionDuration = "14400"
+
+	session, err := ParseSessionAttributes(idp.samlResponse(time.Now()))
+	if err != nil {
+		t.Fatalf("ParseSessionAttributes() error = %v", err)
+	}
+
+	if session.SessionDuration != 4*time.Hour || session.RoleSessionName != testUsername || session.MaxDurationHours() != 4 {
+		t.Errorf("ParseSessionAttributes() = %+v, want 4h session of %s", session, testUsername)
+	}
+
+	idp.sessionDuration = "not a duration"
+	if _, err := ParseSessionAttributes(idp.samlResponse(time.Now())); err == nil {
+		t.Error("ParseSessionAttributes() of an invalid SessionDuration succeeded, want an error")
+	}
+
+	idp.sessionDuration = ""
+	if session, err := ParseSessionAttributes(idp.samlResponse(time.Now())); err != nil || session.MaxDurationHours() != 0 {
+		t.Errorf("ParseSessionAttributes() without SessionDuration = %+v, %v, want no session duration", session, err)
+	}
+}
+
+func TestAskUserForDurationWithSessionDuration(t *testing.T) {
+	session := SessionAttributes{SessionDuration: 4 * time.Hour, RoleSessionName: testUsername}
+
+	tests := []struct {
+		name                 string
+		defaultDurationHours string
+		session              SessionAttributes
+		want                 int32
+	}{
+		{name: "profile default", defaultDurationHours: "2", session: session, want: 2},
+		{name: "session duration default", session: session, want: 4},
+		{name: "session duration ceiling", defaultDurationHours: "8", session: session, want: 4},
+		{name: "maximum ceiling", defaultDurationHours: "24", want: MAX_DURATION_HOURS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			durationHours, err := AskUserForDuration(tt.session, Options{NoPrompt: true, Profile: ProfileConfig{AzureDefaultDurationHours: tt.defaultDurationHours}})
+			if err != nil {
+				t.Fatalf("AskUserForDuration() error = %v", err)
+			}
+
+			if durationHours != tt.want {
+				t.Errorf("AskUserForDuration() = %d, want %d", durationHours, tt.want)
+			}
+		})
+	}
+}
+
+func TestAskUserForRoleAndDurationWithDefaults(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	opts := Options{
+		NoPrompt: true,
+		Profile:  ProfileConfig{AzureDefaultRoleArn: testAdminArn, AzureDefaultDurationHours: "4"},
+		Prompter: failingPrompter{t},
+	}
+
+	rl, durationHours, err := AskUserForRoleAndDuration(idp.roles, SessionAttributes{}, opts)
+	if err != nil {
+		t.Fatalf("AskUserForRoleAndDuration() error = %v", err)
+	}
+
+	if rl.RoleArn != testAdminArn || durationHours != 4 {
+		t.Errorf("AskUserForRoleAndDuration() = %s, %d, want %s, 4", rl.RoleArn, durationHours, testAdminArn)
+	}
+
+	if _, _, err := AskUserForRoleAndDuration(nil, SessionAttributes{}, opts); !errors.Is(err, ErrNoRoles) {
+		t.Errorf("AskUserForRoleAndDuration() without roles error = %v, want %v", err, ErrNoRoles)
+	}
+}
+
+func TestAskUserForRoleAndDurationWithPrompter(t *testing.T) {
+	idp := newFakeIdP(t)
+	prompter := &answersPrompter{t: t, answers: []string{testAdminArn, "5", "2"}}
+
+	rl, durationHours, err := AskUserForRoleAndDuration(idp.roles, SessionAttributes{SessionDuration: 4 * time.Hour}, Options{Prompter: prompter, Output: io.Discard})
+	if err != nil {
+		t.Fatalf("AskUserForRoleAndDuration() error = %v", err)
+	}
+
+	if rl.RoleArn != testAdminArn || durationHours != 2 {
+		t.Errorf("AskUserForRoleAndDuration() = %s, %d, want %s, 2", rl.RoleArn, durationHours, testAdminArn)
+	}
+
+	if len(prompter.answers) != 0 {
+		t.Errorf("AskUserForRoleAndDuration() left answers %v", prompter.answers)
+	}
+
+	prompter = &answersPrompter{t: t, answers: []string{"0", "13", "x"}}
+	if _, err := AskUserForDuration(SessionAttributes{}, Options{Prompter: prompter, Output: io.Discard}); err == nil {
+		t.Error("AskUserForDuration() with invalid durations error = nil, want error")
+	}
+}
+
+func TestProfileEndpoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		profile   ProfileConfig
+		authority string
+		acs       string
+	}{
+		{
+			name:      "default",
+			profile:   ProfileConfig{},
+			authority: "https://login.microsoftonline.com",
+			acs:       AWS_SAML_ENDPOINT,
+		},
+		{
+			name:      "china region",
+			profile:   ProfileConfig{Region: StringToPointer("cn-north-1")},
+			authority: "https://login.microsoftonline.com",
+			acs:       AWS_CN_SAML_ENDPOINT,
+		},
+		{
+			name:      "authority host",
+			profile:   ProfileConfig{AzureAuthorityHost: StringToPointer("login.microsoftonline.us"), Region: StringToPointer("us-gov-west-1")},
+			authority: "https://login.microsoftonline.us",
+			acs:       AWS_GOV_SAML_ENDPOINT,
+		},
+		{
+			name:      "usgov cloud",
+			profile:   ProfileConfig{AzureCloudName: StringToPointer(AZURE_CLOUD_USGOV), Region: StringToPointer("us-gov-east-1")},
+			authority: "https://login.microsoftonline.us",
+			acs:       AWS_GOV_SAML_ENDPOINT,
+		},
+		{
+			name:      "china cloud",
+			profile:   ProfileConfig{AzureCloudName: StringToPointer("China"), Region: StringToPointer("cn-northwest-1")},
+			authority: "https://login.partner.microsoftonline.cn",
+			acs:       AWS_CN_SAML_ENDPOINT,
+		},
+		{
+			name:      "authority URL and ACS URL",
+			profile:   ProfileConfig{AzureAuthorityHost: StringToPointer("http://127.0.0.1:8080/"), AwsSamlAcsUrl: StringToPointer("https://aws.example.com/saml"), Region: StringToPointer("us-gov-west-1")},
+			authority: "http://127.0.0.1:8080",
+			acs:       "https://aws.example.com/saml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if authority := tt.profile.AuthorityURL(); authority != tt.authority {
+				t.Errorf("AuthorityURL() = %s, want %s", authority, tt.authority)
+			}
+
+			if acs := tt.profile.AssertionConsumerServiceURL(); acs != tt.acs {
+				t.Errorf("AssertionConsumerServiceURL() = %s, want %s", acs, tt.acs)
+			}
+
+			loginUrl := createLoginUrl(tt.profile.AuthorityURL(), testAppIDUri, testTenantID, tt.profile.AssertionConsumerServiceURL())
+			if want := tt.authority + "/" + testTenantID + "/saml2?SAMLRequest="; !strings.HasPrefix(loginUrl, want) {
+				t.Errorf("createLoginUrl() = %s, want prefix %s", loginUrl, want)
+			}
+		})
+	}
+}
+
+func TestValidateAzureCloud(t *testing.T) {
+	tests := []struct {
+		cloud  *string
+		region *string
+		valid  bool
+	}{
+		{cloud: nil, region: nil, valid: true},
+		{cloud: nil, region: StringToPointer("us-gov-west-1"), valid: true},
+		{cloud: nil, region: StringToPointer("cn-north-1"), valid: true},
+		{cloud: StringToPointer(AZURE_CLOUD_PUBLIC), region: StringToPointer("eu-west-1"), valid: true},
+		{cloud: StringToPointer(AZURE_CLOUD_USGOV), region: StringToPointer("us-gov-west-1"), valid: true},
+		{cloud: StringToPointer(AZURE_CLOUD_USGOV), region: StringToPointer("us-east-1"), valid: false},
+		{cloud: StringToPointer(AZURE_CLOUD_USGOV), region: nil, valid: false},
+		{cloud: StringToPointer(AZURE_CLOUD_CHINA), region: StringToPointer("cn-north-1"), valid: true},
+		{cloud: StringToPointer(AZURE_CLOUD_CHINA), region: StringToPointer("us-gov-west-1"), valid: false},
+		{cloud: StringToPointer("germany"), region: nil, valid: false},
+	}
+
+	for _, tt := range tests {
+		profile := ProfileConfig{AzureCloudName: tt.cloud, Region: tt.region}
+
+		err := profile.ValidateAzureCloud()
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateAzureCloud() of cloud %v and region %v error = %v, want valid %v", stringValue(tt.cloud), stringValue(tt.region), err, tt.valid)
+		}
+
+		if err != nil && ExitCode(err) != EXIT_CONFIGURATION_ERROR {
+			t.Errorf("ValidateAzureCloud() exit code = %d, want %d", ExitCode(err), EXIT_CONFIGURATION_ERROR)
+		}
+	}
+}
+
+func stringValue(p *string) string {
+	if p == nil {
+		return "<nil>"
+	}
+	return *p
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		secrets  staticSecrets
+		pages    []string
+	}{
+		{
+			name:     "azure",
+			username: testUsername,
+			secrets:  staticSecrets{AZURE_PASSWORD_SECRET: testPassword, AZURE_TOTP_SECRET: testCode},
+			pages:    []string{"azure username", "azure password", "azure verification code", "azure stay signed in", "saml post"},
+		},
+		{
+			name:     "okta",
+			username: "jane@" + testOktaDomain,
+			secrets:  staticSecrets{OKTA_PASSWORD_SECRET: testPassword},
+			pages:    []string{"azure username", "okta identifier", "okta password", "okta select authenticator", "okta push", "saml post"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireBrowser(t)
+
+			idp := newFakeIdP(t)
+			idp.acsURL = "https://signin.aws.example.com/saml"
+
+			sts := newFakeSTS(t, idp.roles)
+			isolateAWSConfig(t)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			credentials, err := Login(ctx, Options{
+				Profile: ProfileConfig{
+					AzureTenantID:             testTenantID,
+					AzureAppIDUri:             testAppIDUri,
+					AzureDefaultUsername:      tt.username,
+					AzureDefaultRoleArn:       testAdminArn,
+					AzureDefaultDurationHours: "1",
+					AzureAuthorityHost:        &idp.URL,
+					AwsSamlAcsUrl:             &idp.acsURL,
+					AwsStsEndpoint:            &sts.URL,
+					AzureVerifySAML:           true,
+				},
+				NoPrompt: true,
+				Prompter: failingPrompter{t},
+				Secrets:  tt.secrets,
+				Output:   io.Discard,
+			})
+			if err != nil {
+				t.Fatalf("Login() error = %v, pages %v", err, idp.visited())
+			}
+
+			if pages := idp.visited(); !slices.Equal(pages, tt.pages) {
+				t.Errorf("Login() visited %v, want %v", pages, tt.pages)
+			}
+
+			if credentials.AwsAccessKeyID != "ASIAFAKEACCESSKEYID" {
+				t.Errorf("Login() = %+v, want the credentials of the fake STS", credentials)
+			}
+
+			if req := sts.lastRequest(); req["RoleArn"] != testAdminArn || req["DurationSeconds"] != "3600" {
+				t.Errorf("AssumeRoleWithSAML request = %v, want role %s for 3600 seconds", req, testAdminArn)
+			}
+		})
+	}
+}
+
+func TestPerformLoginErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		username string
+		secrets  staticSecrets
+		exitCode int
+	}{
+		{
+			name:     "wrong azure password",
+			username: testUsername,
+			secrets:  staticSecrets{AZURE_PASSWORD_SECRET: "wrong"},
+			exitCode: EXIT_INVALID_CREDENTIALS,
+		},
+		{
+			name:     "wrong okta password",
+			username: "jane@" + testOktaDomain,
+			secrets:  staticSecrets{OKTA_PASSWORD_SECRET: "wrong"},
+			exitCode: EXIT_INVALID_CREDENTIALS,
+		},
+		{
+			name:     "wrong azure verification code",
+			username: testUsername,
+			secrets:  staticSecrets{AZURE_PASSWORD_SECRET: testPassword, AZURE_TOTP_SECRET: "000000"},
+			exitCode: EXIT_MFA_FAILED,
+		},
+		{
+			name:     "access blocked",
+			path:     "/azure/error",
+			exitCode: EXIT_ACCESS_BLOCKED,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireBrowser(t)
+
+			idp := newFakeIdP(t)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			session := &LoginSession{
+				Profile:  ProfileConfig{AzureDefaultUsername: tt.username},
+				NoPrompt: true,
+				Prompter: failingPrompter{t},
+				Secrets:  tt.secrets,
+				Output:   io.Discard,
+			}
+
+			loginURL := idp.loginURL()
+			if tt.path != "" {
+				loginURL = idp.URL + tt.path
+			}
+
+			_, err := performLogin(ctx, loginURL, AWS_SAML_ENDPOINT, session, false, "", "")
+
+			var lErr *LoginError
+			if !errors.As(err, &lErr) {
+				t.Fatalf("performLogin() error = %v, want a login error", err)
+			}
+
+			if lErr.ExitCode != tt.exitCode {
+				t.Errorf("performLogin() exit code = %d, want %d (%v)", lErr.ExitCode, tt.exitCode, err)
+			}
+		})
+	}
+}
+
+func TestPerformLoginRetriesWrongOktaPassword(t *testing.T) {
+	requireBrowser(t)
+
+	idp := newFakeIdP(t)
+	username := "jane@" + testOktaDomain
+	prompter := &answersPrompter{t: t, answers: []string{username, username, testPassword}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	session := &LoginSession{
+		Profile:  ProfileConfig{AzureDefaultUsername: username},
+		Prompter: prompter,
+		Secrets:  staticSecrets{OKTA_PASSWORD_SECRET: "wrong"},
+		Output:   io.Discard,
+	}
+
+	// the form error of Okta is shown in an infobox, the user can fix it when prompted
+	if _, err := performLogin(ctx, idp.loginURL(), AWS_SAML_ENDPOINT, session, false, "", ""); err != nil {
+		t.Fatalf("performLogin() error = %v, pages %v", err, idp.visited())
+	}
+
+	if len(prompter.answers) != 0 {
+		t.Errorf("performLogin() left answers %v", prompter.answers)
+	}
+}
+
+func TestPerformLoginBrowserNotFound(t *testing.T) {
+	bin := defaults.Bin
+	defaults.Bin = filepath.Join(t.TempDir(), "chromium")
+	t.Cleanup(func() { defaults.Bin = bin })
+
+	session := &LoginSession{NoPrompt: true, Prompter: failingPrompter{t}, Secrets: staticSecrets{}, Output: io.Discard}
+
+	if _, err := performLogin(context.Background(), "http://127.0.0.1/", AWS_SAML_ENDPOINT, session, true, "", ""); err == nil {
+		t.Error("performLogin() without a browser succeeded, want an error")
+	}
+}
+
+func TestPerformLoginRegisteredState(t *testing.T) {
+	requireBrowser(t)
+
+	registeredStatesMu.Lock()
+	saved := registeredStates
+	registeredStatesMu.Unlock()
+
+	t.Cleanup(func() {
+		registeredStatesMu.Lock()
+		registeredStates = saved
+		registeredStatesMu.Unlock()
+	})
+
+	accepted := false
+
+	RegisterState(State{
+		Name:     "terms of use",
+		Selector: `#termsOfUse button[type=submit]`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			accepted = true
+			wait := s.Page.MustWaitRequestIdle()
+			el.MustClick()
+			wait()
+			return nil
+		}),
+	})
+
+	idp := newFakeIdP(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	session := &LoginSession{NoPrompt: true, Prompter: failingPrompter{t}, Secrets: staticSecrets{}, Output: io.Discard}
+
+	saml, err := performLogin(ctx, idp.URL+"/azure/terms", AWS_SAML_ENDPOINT, session, false, "", "")
+	if err != nil {
+		t.Fatalf("performLogin() error = %v, pages %v", err, idp.visited())
+	}
+
+	if !accepted || saml == "" {
+		t.Errorf("performLogin() accepted = %v, SAML response %q, want the terms accepted and a SAML response", accepted, saml)
+	}
+}
+
+func TestGetStatesRegisteredFirst(t *testing.T) {
+	registeredStatesMu.Lock()
+	saved := registeredStates
+	registeredStates = nil
+	registeredStatesMu.Unlock()
+
+	t.Cleanup(func() {
+		registeredStatesMu.Lock()
+		registeredStates = saved
+		registeredStatesMu.Unlock()
+	})
+
+	RegisterState(State{Name: "terms of use", Selector: `#termsOfUse`})
+	RegisterState(State{Name: "username input", Selector: `#companyUsername`})
+
+	got := getStates()
+	if len(got) != len(states)+2 {
+		t.Fatalf("getStates() returned %d states, want %d", len(got), len(states)+2)
+	}
+
+	if got[0].Selector != `#termsOfUse` || got[1].Selector != `#companyUsername` || got[2].Name != states[0].Name {
+		t.Errorf("getStates() = %s, %s, %s, want the registered states first", got[0].Name, got[1].Selector, got[2].Name)
+	}
+}
+
+func TestPerformLoginTimeout(t *testing.T) {
+	requireBrowser(t)
+
+	idp := newFakeIdP(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// no state handles the terms of use page, the login stays on it
+	session := &LoginSession{NoPrompt: true, Prompter: failingPrompter{t}, Secrets: staticSecrets{}, Output: io.Discard}
+
+	_, err := performLogin(ctx, idp.URL+"/azure/terms", AWS_SAML_ENDPOINT, session, false, "", "")
+
+	if code := ExitCode(err); code != EXIT_TIMEOUT {
+		t.Errorf("performLogin() exit code = %d, want %d (%v)", code, EXIT_TIMEOUT, err)
+	}
+}
+
+func TestStoredPasswordRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		session  LoginSession
+		wantCode int
+	}{
+		{name: "no prompt", session: LoginSession{NoPrompt: true}, wantCode: EXIT_INVALID_CREDENTIALS},
+		{name: "prompt", session: LoginSession{}},
+		{name: "gui", session: LoginSession{Gui: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.session
+			s.Secrets = staticSecrets{AZURE_PASSWORD_SECRET: testPassword}
+
+			// an error shown before the password was submitted is not about it
+			if password, err := s.storedPassword(AZURE_PASSWORD_SECRET, true); err != nil || password == nil {
+				t.Fatalf("storedPassword() = %v, %v, want the password", password, err)
+			}
+
+			password, err := s.storedPassword(AZURE_PASSWORD_SECRET, true)
+			if password != nil {
+				t.Errorf("storedPassword() of a rejected password = %q, want nil", *password)
+			}
+
+			var loginErr *LoginError
+			if tt.wantCode != 0 && (!errors.As(err, &loginErr) || loginErr.ExitCode != tt.wantCode) {
+				t.Errorf("storedPassword() of a rejected password error = %v, want exit code %d", err, tt.wantCode)
+			} else if tt.wantCode == 0 && err != nil {
+				t.Errorf("storedPassword() of a rejected password error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestStoredVerificationCodeRejected(t *testing.T) {
+	s := LoginSession{NoPrompt: true, Secrets: staticSecrets{AZURE_TOTP_SECRET: testCode}}
+
+	// an error shown before the code was submitted is not about it
+	if code, err := s.storedVerificationCode(AZURE_TOTP_SECRET, true); err != nil || code == nil {
+		t.Fatalf("storedVerificationCode() = %v, %v, want the code", code, err)
+	}
+
+	if code, err := s.storedVerificationCode(AZURE_TOTP_SECRET, false); err != nil || code == nil {
+		t.Fatalf("storedVerificationCode() = %v, %v, want the code", code, err)
+	}
+
+	code, err := s.storedVerificationCode(AZURE_TOTP_SECRET, true)
+	if code != nil {
+		t.Errorf("storedVerificationCode() of a rejected code = %q, want nil", *code)
+	}
+
+	var loginErr *LoginError
+	if !errors.As(err, &loginErr) || loginErr.ExitCode != EXIT_MFA_FAILED {
+		t.Errorf("storedVerificationCode() of a rejected code error = %v, want exit code %d", err, EXIT_MFA_FAILED)
+	}
+}
+
+func TestPasswordSourceRetriesFailedCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the password command uses sh")
+	}
+
+	ready := filepath.Join(t.TempDir(), "ready")
+	command := fmt.Sprintf("test -f %s && echo %s", ready, testPassword)
+
+	getPassword := getPasswordSource(&command, nil, false, io.Discard)
+
+	if password := getPassword(); password != nil {
+		t.Fatalf("getPassword() of a failing command = %q, want nil", *password)
+	}
+
+	if err := os.WriteFile(ready, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if password := getPassword(); password == nil || *password != testPassword {
+		t.Errorf("getPassword() = %v, want %q", password, testPassword)
+	}
+}
+
+func TestGenerateTotpCode(t *testing.T) {
+	// RFC 6238 Appendix B test vectors of the SHA1 secret "12345678901234567890", truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := generateTotpCode(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("generateTotpCode() error = %v", err)
+		}
+
+		if code != tt.want {
+			t.Errorf("generateTotpCode() at %d = %s, want %s", tt.unix, code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package azurelogin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// getPasswordSource returns a function giving the password to fill in without prompting: the output of
+// the password command when one is configured, run when first needed and again until it succeeds, or the
+// default password with -no-prompt.
+func getPasswordSource(command *string, defaultPassword *string, noPrompt bool, out io.Writer) func() *string {
+	var mu sync.Mutex
+	var password *string
+
+	return func() *string {
+		if command == nil {
+			if noPrompt {
+				return defaultPassword
+			}
+			return nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if password == nil {
+			p, err := runPasswordCommand(*command)
+			if err != nil {
+				fmt.Fprintf(out, "Fail to run password command: %v\n", err)
+				return nil
+			}
+			password = &p
+		}
+
+		return password
+	}
+}
+
+// runPasswordCommand runs the command through the shell and returns the first line of its output.
+func runPasswordCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	var stdout bytes.Buffer
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	password := strings.TrimRight(strings.SplitN(stdout.String(), "\n", 2)[0], "\r")
+	if password == "" {
+		return "", fmt.Errorf("%q printed an empty password", command)
+	}
+
+	return password, nil
+}
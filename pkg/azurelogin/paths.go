@@ -0,0 +1,53 @@
+package azurelogin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type PathType string
+
+const (
+	AWSDIR      PathType = "awsDir"
+	CONFIG      PathType = "config"
+	CREDENTIALS PathType = "credentials"
+	CHROMIUM    PathType = "chromium"
+	CACHE       PathType = "cache"
+	SECRETS     PathType = "secrets"
+)
+
+var userHomeDir, _ = os.UserHomeDir()
+var awsDir = filepath.Join(userHomeDir, ".aws")
+
+// Paths are the locations of the AWS files and of the files of the tool
+var Paths = map[PathType]string{
+	AWSDIR:      awsDir,
+	CONFIG:      ifThenElse(os.Getenv("AWS_CONFIG_FILE") != "", os.Getenv("AWS_CONFIG_FILE"), filepath.Join(awsDir, string(CONFIG))),
+	CREDENTIALS: ifThenElse(os.Getenv("AWS_SHARED_CREDENTIALS_FILE") != "", os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), filepath.Join(awsDir, string(CREDENTIALS))),
+	CHROMIUM:    filepath.Join(awsDir, string(CHROMIUM)),
+	CACHE:       filepath.Join(awsDir, "azure-login", string(CACHE)),
+	SECRETS:     filepath.Join(awsDir, "azure-login", string(SECRETS)),
+}
+
+func ifThenElse(condition bool, a string, b string) string {
+	if condition {
+		return a
+	}
+	return b
+}
+
+// GetChromiumUserDataDir returns the Chromium user data directory used to remember the Azure session
+// of a user in a tenant, so profiles sharing a tenant and a default username share the session cookies.
+func GetChromiumUserDataDir(tenantID string, username string) string {
+	dirName := tenantID
+	if username != "" {
+		dirName += "_" + strings.ToLower(username)
+	}
+
+	dirName = strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(dirName)
+	if dirName == "" {
+		dirName = "default"
+	}
+	return filepath.Join(Paths[CHROMIUM], dirName)
+}
@@ -0,0 +1,28 @@
+package azurelogin
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGetChromiumUserDataDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		tenantID string
+		username string
+		want     string
+	}{
+		{name: "tenant", tenantID: testTenantID, want: testTenantID},
+		{name: "tenant and username", tenantID: testTenantID, username: "Jane@Example.com", want: testTenantID + "_jane@example.com"},
+		{name: "path separators", tenantID: "../tenant", username: "a/b\\c", want: "__tenant_a_b_c"},
+		{name: "none", want: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetChromiumUserDataDir(tt.tenantID, tt.username); got != filepath.Join(Paths[CHROMIUM], tt.want) {
+				t.Errorf("GetChromiumUserDataDir(%q, %q) = %s, want %s in %s", tt.tenantID, tt.username, got, tt.want, Paths[CHROMIUM])
+			}
+		})
+	}
+}
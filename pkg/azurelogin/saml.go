@@ -0,0 +1,224 @@
+package azurelogin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ROLE_ATTRIBUTE              = "https://aws.amazon.com/SAML/Attributes/Role"
+	SESSION_DURATION_ATTRIBUTE  = "https://aws.amazon.com/SAML/Attributes/SessionDuration"
+	ROLE_SESSION_NAME_ATTRIBUTE = "https://aws.amazon.com/SAML/Attributes/RoleSessionName"
+)
+
+// MAX_DURATION_HOURS is the longest session duration of a role
+const MAX_DURATION_HOURS = 12
+
+const samlValidityMargin = 30 * time.Second
+
+// SAMLResponse is the SAML response posted by the identity provider to the AWS SAML endpoint.
+type SAMLResponse struct {
+	XMLName   xml.Name
+	Assertion SAMLAssertion `xml:"Assertion"`
+}
+
+type SAMLAssertion struct {
+	XMLName            xml.Name
+	Conditions         SAMLConditions
+	AttributeStatement SAMLAttributeStatement
+}
+
+type SAMLConditions struct {
+	XMLName      xml.Name
+	NotBefore    string `xml:",attr"`
+	NotOnOrAfter string `xml:",attr"`
+}
+
+type SAMLAttributeValue struct {
+	XMLName xml.Name
+	Type    string `xml:"xsi:type,attr"`
+	Value   string `xml:",innerxml"`
+}
+
+type SAMLAttribute struct {
+	XMLName         xml.Name
+	Name            string               `xml:",attr"`
+	AttributeValues []SAMLAttributeValue `xml:"AttributeValue"`
+}
+
+type SAMLAttributeStatement struct {
+	XMLName    xml.Name
+	Attributes []SAMLAttribute `xml:"Attribute"`
+}
+
+// SessionAttributes are the attributes of the SAML response about the role session.
+type SessionAttributes struct {
+	// SessionDuration is the longest session duration allowed, 0 when not set
+	SessionDuration time.Duration
+	// RoleSessionName is the name of the role session, usually the user name
+	RoleSessionName string
+}
+
+// MaxDurationHours returns the longest session duration allowed in whole hours, at least 1, or 0 when not set.
+func (a SessionAttributes) MaxDurationHours() int32 {
+	if a.SessionDuration <= 0 {
+		return 0
+	}
+
+	return int32(max(1, min(MAX_DURATION_HOURS, int(a.SessionDuration/time.Hour))))
+}
+
+// Role is an AWS role that can be assumed with the SAML response.
+type Role struct {
+	RoleArn      string
+	PrincipalArn string
+}
+
+// ParseSAMLResponse decodes the base64 encoded SAML response.
+func ParseSAMLResponse(assertion string) (*SAMLResponse, error) {
+	var sResponse SAMLResponse
+
+	b64, err := base64.StdEncoding.DecodeString(assertion)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := xml.Unmarshal(b64, &sResponse); err != nil {
+		return nil, err
+	}
+
+	// only the last one would be read
+	if n, err := countAssertions(b64); err != nil || n > 1 {
+		return nil, errors.New("the SAML response has more than one assertion")
+	}
+
+	return &sResponse, nil
+}
+
+// countAssertions returns the number of Assertion elements of the SAML response, at any depth.
+func countAssertions(b []byte) (int, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	n := 0
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "Assertion" {
+			n++
+		}
+	}
+}
+
+// IsSAMLResponseValid tells if the SAML response can still be used, see SAMLResponse.IsValid.
+func IsSAMLResponseValid(assertion string) bool {
+	sResponse, err := ParseSAMLResponse(assertion)
+	if err != nil {
+		return false
+	}
+
+	return sResponse.IsValid()
+}
+
+// IsValid tells if the SAML response can still be used, i.e. it is not about to reach the
+// NotOnOrAfter condition of its assertion.
+func (r *SAMLResponse) IsValid() bool {
+	notOnOrAfter, err := time.Parse(time.RFC3339, r.Assertion.Conditions.NotOnOrAfter)
+	if err != nil {
+		return false
+	}
+
+	return time.Until(notOnOrAfter) > samlValidityMargin
+}
+
+// ParseRoles returns the roles of the SAML response.
+func ParseRoles(assertion string) ([]Role, error) {
+	sResponse, err := ParseSAMLResponse(assertion)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sResponse.Roles()
+}
+
+// Roles returns the roles of the role attribute, each value being a comma separated role ARN and principal ARN.
+func (r *SAMLResponse) Roles() ([]Role, error) {
+	var roles []Role
+
+	for _, attr := range r.Assertion.AttributeStatement.Attributes {
+		if attr.Name == ROLE_ATTRIBUTE {
+			for _, val := range attr.AttributeValues {
+				parts := strings.Split(val.Value, ",")
+				if len(parts) != 2 {
+					return nil, errors.New("invalid role attribute value: " + val.Value)
+				}
+
+				if strings.Contains(parts[0], ":role/") {
+					roles = append(roles, Role{
+						RoleArn:      strings.TrimSpace(parts[0]),
+						PrincipalArn: strings.TrimSpace(parts[1]),
+					})
+				} else {
+					roles = append(roles, Role{
+						RoleArn:      strings.TrimSpace(parts[1]),
+						PrincipalArn: strings.TrimSpace(parts[0]),
+					})
+				}
+
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+// ParseSessionAttributes returns the session attributes of the SAML response.
+func ParseSessionAttributes(assertion string) (SessionAttributes, error) {
+	sResponse, err := ParseSAMLResponse(assertion)
+
+	if err != nil {
+		return SessionAttributes{}, err
+	}
+
+	return sResponse.SessionAttributes()
+}
+
+// SessionAttributes returns the session duration, in seconds in its attribute, and the role session name.
+func (r *SAMLResponse) SessionAttributes() (SessionAttributes, error) {
+	var attributes SessionAttributes
+
+	if val := r.attributeValue(SESSION_DURATION_ATTRIBUTE); val != "" {
+		seconds, err := strconv.ParseInt(val, 10, 32)
+		if err != nil || seconds <= 0 {
+			return attributes, errors.New("invalid session duration attribute value: " + val)
+		}
+		attributes.SessionDuration = time.Duration(seconds) * time.Second
+	}
+
+	attributes.RoleSessionName = r.attributeValue(ROLE_SESSION_NAME_ATTRIBUTE)
+
+	return attributes, nil
+}
+
+// attributeValue returns the first value of the attribute, empty when it is not set.
+func (r *SAMLResponse) attributeValue(name string) string {
+	for _, attr := range r.Assertion.AttributeStatement.Attributes {
+		if attr.Name == name && len(attr.AttributeValues) > 0 {
+			return strings.TrimSpace(attr.AttributeValues[0].Value)
+		}
+	}
+
+	return ""
+}
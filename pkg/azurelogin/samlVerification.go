@@ -0,0 +1,312 @@
+package azurelogin
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// samlClockSkew is the clock difference with Azure AD tolerated when checking the validity period of the assertion
+const samlClockSkew = 2 * time.Minute
+
+// SAMLVerification is what a SAML response is verified against.
+type SAMLVerification struct {
+	// Certificates are the certificates the response or its assertion can be signed with
+	Certificates []*x509.Certificate
+	// Audience is the expected audience of the assertion, the App ID URI, not checked when empty
+	Audience string
+	// Destination is the expected destination of the response and recipient of the assertion, the AWS SAML
+	// endpoint, not checked when empty
+	Destination string
+}
+
+// VerifySAMLResponse checks that the base64 encoded SAML response or its assertion is signed with one of the
+// certificates, that the assertion is within its validity period and for the audience, and that the signed
+// Destination of the response and Recipient of the assertion are the destination. It returns the SAML response
+// read from the signed XML only, whose roles and attributes can be trusted. The returned error is a *LoginError
+// whose code is the failed condition.
+func VerifySAMLResponse(assertion string, v SAMLVerification) (*SAMLResponse, error) {
+	b, err := base64.StdEncoding.DecodeString(assertion)
+	if err != nil {
+		return nil, newSAMLVerificationError("Format", "", "the SAML response is not base64 encoded: %v", err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(b); err != nil {
+		return nil, newSAMLVerificationError("Format", "", "the SAML response is not valid XML: %v", err)
+	}
+
+	response := doc.Root()
+	if response == nil || response.Tag != "Response" {
+		return nil, newSAMLVerificationError("Format", "", "the SAML response has no Response element")
+	}
+
+	// an unsigned assertion next to the signed one would be read instead of it (signature wrapping)
+	if n, err := countAssertions(b); err != nil || n > 1 {
+		return nil, newSAMLVerificationError("Format", "", "the SAML response has more than one assertion")
+	}
+
+	validationContext := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: v.Certificates})
+	responseSigned, assertionSigned := false, false
+
+	// only the signed elements are trusted, the validation returns them without their signature
+	if response.SelectElement("Signature") != nil {
+		validated, err := validationContext.Validate(response)
+		if err != nil {
+			return nil, newSAMLVerificationError("Signature", certificateHint, "the signature of the SAML response is invalid: %v", err)
+		}
+		response = validated
+		responseSigned = true
+	}
+
+	assertionEl := response.SelectElement("Assertion")
+	if assertionEl == nil {
+		return nil, newSAMLVerificationError("Format", "", "the SAML response has no (unencrypted) assertion")
+	}
+
+	if assertionEl.SelectElement("Signature") != nil {
+		validated, err := validationContext.Validate(assertionEl)
+		if err != nil {
+			return nil, newSAMLVerificationError("Signature", certificateHint, "the signature of the assertion is invalid: %v", err)
+		}
+		assertionEl = validated
+		assertionSigned = true
+	}
+
+	if !responseSigned && !assertionSigned {
+		return nil, newSAMLVerificationError("Signature", certificateHint, "neither the SAML response nor its assertion is signed")
+	}
+
+	conditions := assertionEl.SelectElement("Conditions")
+	if conditions == nil {
+		return nil, newSAMLVerificationError("Conditions", "", "the assertion has no conditions")
+	}
+
+	now := time.Now()
+
+	if notBefore := conditions.SelectAttrValue("NotBefore", ""); notBefore != "" {
+		t, err := time.Parse(time.RFC3339, notBefore)
+		if err != nil {
+			return nil, newSAMLVerificationError("NotBefore", "", "invalid NotBefore condition %q", notBefore)
+		}
+		if now.Add(samlClockSkew).Before(t) {
+			return nil, newSAMLVerificationError("NotBefore", clockHint, "the assertion is not valid before %s", notBefore)
+		}
+	}
+
+	notOnOrAfter := conditions.SelectAttrValue("NotOnOrAfter", "")
+	t, err := time.Parse(time.RFC3339, notOnOrAfter)
+	if err != nil {
+		return nil, newSAMLVerificationError("NotOnOrAfter", "", "invalid NotOnOrAfter condition %q", notOnOrAfter)
+	}
+	if !now.Add(-samlClockSkew).Before(t) {
+		return nil, newSAMLVerificationError("NotOnOrAfter", clockHint, "the assertion expired at %s", notOnOrAfter)
+	}
+
+	if v.Audience != "" {
+		var audiences []string
+		for _, restriction := range conditions.SelectElements("AudienceRestriction") {
+			for _, audience := range restriction.SelectElements("Audience") {
+				audiences = append(audiences, strings.TrimSpace(audience.Text()))
+			}
+		}
+
+		if !slices.Contains(audiences, v.Audience) {
+			return nil, newSAMLVerificationError("Audience", "Check that azure_app_id_uri is the Identifier (Entity ID) of the Azure AD application.", "the assertion is for the audience %q, not %q", strings.Join(audiences, ", "), v.Audience)
+		}
+	}
+
+	// the destination of the response is only trusted when the response is signed, the recipients of the
+	// subject confirmations of the signed assertion are checked too
+	if v.Destination != "" {
+		var destinations []string
+
+		if responseSigned {
+			destinations = append(destinations, response.SelectAttrValue("Destination", ""))
+		}
+
+		for _, data := range assertionEl.FindElements("./Subject/SubjectConfirmation/SubjectConfirmationData[@Recipient]") {
+			destinations = append(destinations, data.SelectAttrValue("Recipient", ""))
+		}
+
+		if len(destinations) == 0 {
+			return nil, newSAMLVerificationError("Destination", "", "neither the SAML response nor the recipient of its assertion is signed")
+		}
+
+		for _, destination := range destinations {
+			if destination != v.Destination {
+				return nil, newSAMLVerificationError("Destination", "Check the region and aws_saml_acs_url profile properties.", "the SAML response is for the destination %q, not %q", destination, v.Destination)
+			}
+		}
+	}
+
+	return newVerifiedSAMLResponse(assertionEl)
+}
+
+// newVerifiedSAMLResponse returns the SAML response made of the verified assertion element.
+func newVerifiedSAMLResponse(assertionEl *etree.Element) (*SAMLResponse, error) {
+	doc := etree.NewDocument()
+	doc.SetRoot(assertionEl.Copy())
+
+	b, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, newSAMLVerificationError("Format", "", "fail to read the verified assertion: %v", err)
+	}
+
+	var sResponse SAMLResponse
+	if err := xml.Unmarshal(b, &sResponse.Assertion); err != nil {
+		return nil, newSAMLVerificationError("Format", "", "fail to read the verified assertion: %v", err)
+	}
+
+	return &sResponse, nil
+}
+
+const (
+	certificateHint = "Check the azure_saml_certificate or azure_federation_metadata_url profile property, the Azure AD application may sign with its own certificate."
+	clockHint       = "Check the clock of this computer."
+)
+
+func newSAMLVerificationError(condition string, hint string, format string, args ...any) *LoginError {
+	return &LoginError{
+		Code:        condition,
+		Message:     fmt.Sprintf(format, args...),
+		Explanation: "The SAML response failed verification",
+		Hint:        hint,
+		ExitCode:    EXIT_LOGIN_FAILED,
+	}
+}
+
+// FederationMetadataURL returns the URL of the federation metadata of the tenant, unless azure_federation_metadata_url
+// is set, e.g. to the App Federation Metadata Url of an application signing with its own certificate.
+func (p ProfileConfig) FederationMetadataURL() string {
+	if p.AzureFederationMetadataURL != nil && *p.AzureFederationMetadataURL != "" {
+		return *p.AzureFederationMetadataURL
+	}
+
+	return fmt.Sprintf("%s/%s/federationmetadata/2007-06/federationmetadata.xml", p.AuthorityURL(), p.AzureTenantID)
+}
+
+// SAMLCertificates returns the certificates the SAML responses of the profile are signed with, the pinned
+// azure_saml_certificate or the signing certificates of the federation metadata.
+func (p ProfileConfig) SAMLCertificates(ctx context.Context) ([]*x509.Certificate, error) {
+	if p.AzureSAMLCertificate != nil && *p.AzureSAMLCertificate != "" {
+		return parseCertificates(*p.AzureSAMLCertificate)
+	}
+
+	return fetchFederationMetadataCertificates(ctx, p.FederationMetadataURL())
+}
+
+// parseCertificates parses the certificates of a PEM file, or a base64 encoded DER certificate.
+func parseCertificates(value string) ([]*x509.Certificate, error) {
+	if b, err := os.ReadFile(value); err == nil {
+		var certificates []*x509.Certificate
+
+		for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("fail to parse certificate of %s: %w", value, err)
+			}
+			certificates = append(certificates, certificate)
+		}
+
+		if len(certificates) == 0 {
+			return nil, fmt.Errorf("no PEM certificate found in %s", value)
+		}
+
+		return certificates, nil
+	}
+
+	certificate, err := parseBase64Certificate(value)
+	if err != nil {
+		return nil, fmt.Errorf("azure_saml_certificate is neither a PEM file nor a base64 encoded certificate: %w", err)
+	}
+
+	return []*x509.Certificate{certificate}, nil
+}
+
+func parseBase64Certificate(value string) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+type federationMetadata struct {
+	IDPSSODescriptors []struct {
+		KeyDescriptors []struct {
+			Use          string   `xml:"use,attr"`
+			Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+	} `xml:"IDPSSODescriptor"`
+}
+
+// fetchFederationMetadataCertificates returns the signing certificates of the identity provider of the federation metadata.
+func fetchFederationMetadataCertificates(ctx context.Context, metadataURL string) ([]*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fail to create federation metadata request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get federation metadata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fail to get federation metadata from %s: %s", metadataURL, resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read federation metadata: %w", err)
+	}
+
+	var metadata federationMetadata
+	if err := xml.Unmarshal(b, &metadata); err != nil {
+		return nil, fmt.Errorf("fail to parse federation metadata: %w", err)
+	}
+
+	var certificates []*x509.Certificate
+
+	for _, descriptor := range metadata.IDPSSODescriptors {
+		for _, key := range descriptor.KeyDescriptors {
+			if key.Use != "" && key.Use != "signing" {
+				continue
+			}
+
+			for _, value := range key.Certificates {
+				certificate, err := parseBase64Certificate(value)
+				if err != nil {
+					return nil, fmt.Errorf("fail to parse federation metadata certificate: %w", err)
+				}
+				certificates = append(certificates, certificate)
+			}
+		}
+	}
+
+	if len(certificates) == 0 {
+		return nil, errors.New("no signing certificate found in federation metadata")
+	}
+
+	return certificates, nil
+}
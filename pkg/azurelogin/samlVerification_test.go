@@ -0,0 +1,222 @@
+package azurelogin
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// editSAMLResponse returns the SAML response with its XML edited.
+func editSAMLResponse(t *testing.T, saml string, edit func(string) string) string {
+	t.Helper()
+
+	b, err := base64.StdEncoding.DecodeString(saml)
+	if err != nil {
+		t.Fatalf("fail to decode SAML response: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(edit(string(b))))
+}
+
+// wrappedAssertion is an unsigned assertion granting another role, added to a signed SAML response.
+const wrappedAssertion = `<Assertion xmlns="urn:oasis:names:tc:SAML:2.0:assertion" ID="_wrapped" Version="2.0">` +
+	`<AttributeStatement><Attribute Name="` + ROLE_ATTRIBUTE + `">` +
+	`<AttributeValue>arn:aws:iam::999999999999:role/Admin,` + testIdPArn + `</AttributeValue>` +
+	`</Attribute></AttributeStatement></Assertion>`
+
+func TestVerifySAMLResponse(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	_, otherCertificate, err := dsig.RandomKeyStoreForTest().GetKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := x509.ParseCertificate(otherCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verification := SAMLVerification{
+		Certificates: []*x509.Certificate{idp.certificate()},
+		Audience:     testAppIDUri,
+		Destination:  AWS_SAML_ENDPOINT,
+	}
+
+	tests := []struct {
+		name         string
+		saml         string
+		verification func(v SAMLVerification) SAMLVerification
+		condition    string
+	}{
+		{
+			name: "valid",
+			saml: idp.samlResponse(time.Now()),
+		},
+		{
+			name: "tampered role",
+			saml: editSAMLResponse(t, idp.samlResponse(time.Now()), func(s string) string {
+				return strings.Replace(s, testRoleArn, "arn:aws:iam::999999999999:role/Developer", 1)
+			}),
+			condition: "Signature",
+		},
+		{
+			name: "not signed",
+			saml: editSAMLResponse(t, idp.samlResponse(time.Now()), func(s string) string {
+				return regexp.MustCompile(`<ds:Signature.*</ds:Signature>`).ReplaceAllString(s, "")
+			}),
+			condition: "Signature",
+		},
+		{
+			name: "other certificate",
+			saml: idp.samlResponse(time.Now()),
+			verification: func(v SAMLVerification) SAMLVerification {
+				v.Certificates = []*x509.Certificate{other}
+				return v
+			},
+			condition: "Signature",
+		},
+		{
+			name:      "expired",
+			saml:      idp.samlResponse(time.Now().Add(-10 * time.Minute)),
+			condition: "NotOnOrAfter",
+		},
+		{
+			name:      "not yet valid",
+			saml:      idp.samlResponse(time.Now().Add(10 * time.Minute)),
+			condition: "NotBefore",
+		},
+		{
+			name: "other audience",
+			saml: idp.samlResponse(time.Now()),
+			verification: func(v SAMLVerification) SAMLVerification {
+				v.Audience = "https://signin.aws.amazon.com/saml#other"
+				return v
+			},
+			condition: "Audience",
+		},
+		{
+			name: "other destination",
+			saml: idp.samlResponse(time.Now()),
+			verification: func(v SAMLVerification) SAMLVerification {
+				v.Destination = AWS_GOV_SAML_ENDPOINT
+				return v
+			},
+			condition: "Destination",
+		},
+		{
+			// only the assertion is signed, its recipient is checked
+			name: "unsigned response destination",
+			saml: editSAMLResponse(t, idp.samlResponse(time.Now()), func(s string) string {
+				return strings.Replace(s, `Destination="`+AWS_SAML_ENDPOINT+`"`, `Destination="`+AWS_GOV_SAML_ENDPOINT+`"`, 1)
+			}),
+		},
+		{
+			name: "wrapped assertion",
+			saml: editSAMLResponse(t, idp.samlResponse(time.Now()), func(s string) string {
+				return strings.Replace(s, "</samlp:Response>", wrappedAssertion+"</samlp:Response>", 1)
+			}),
+			condition: "Format",
+		},
+		{
+			name:      "not base64",
+			saml:      "not a SAML response",
+			condition: "Format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := verification
+			if tt.verification != nil {
+				v = tt.verification(v)
+			}
+
+			response, err := VerifySAMLResponse(tt.saml, v)
+
+			if tt.condition == "" {
+				if err != nil {
+					t.Fatalf("VerifySAMLResponse() error = %v, want nil", err)
+				}
+
+				if roles, err := response.Roles(); err != nil || !slices.Equal(roles, idp.roles) {
+					t.Errorf("VerifySAMLResponse() roles = %v, %v, want %v", roles, err, idp.roles)
+				}
+				return
+			}
+
+			var lErr *LoginError
+			if !errors.As(err, &lErr) {
+				t.Fatalf("VerifySAMLResponse() error = %v, want a login error", err)
+			}
+
+			if lErr.Code != tt.condition {
+				t.Errorf("VerifySAMLResponse() failed condition = %s (%v), want %s", lErr.Code, err, tt.condition)
+			}
+		})
+	}
+}
+
+func TestSAMLCertificates(t *testing.T) {
+	idp := newFakeIdP(t)
+	certificate := idp.certificate()
+
+	pemFile := filepath.Join(t.TempDir(), "azure.cer")
+	if err := os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		profile ProfileConfig
+	}{
+		{
+			name:    "tenant federation metadata",
+			profile: ProfileConfig{AzureTenantID: testTenantID, AzureAuthorityHost: &idp.URL},
+		},
+		{
+			name:    "application federation metadata",
+			profile: ProfileConfig{AzureFederationMetadataURL: StringToPointer(idp.URL + "/" + testTenantID + "/federationmetadata/2007-06/federationmetadata.xml?appid=test")},
+		},
+		{
+			name:    "pinned PEM file",
+			profile: ProfileConfig{AzureSAMLCertificate: &pemFile},
+		},
+		{
+			name:    "pinned base64 certificate",
+			profile: ProfileConfig{AzureSAMLCertificate: StringToPointer(base64.StdEncoding.EncodeToString(certificate.Raw))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificates, err := tt.profile.SAMLCertificates(context.Background())
+			if err != nil {
+				t.Fatalf("SAMLCertificates() error = %v", err)
+			}
+
+			if len(certificates) != 1 || !certificates[0].Equal(certificate) {
+				t.Errorf("SAMLCertificates() = %d certificates, want the certificate of the IdP", len(certificates))
+			}
+		})
+	}
+
+	if _, err := (ProfileConfig{AzureSAMLCertificate: StringToPointer("not a certificate")}).SAMLCertificates(context.Background()); err == nil {
+		t.Error("SAMLCertificates() of an invalid pinned certificate succeeded, want an error")
+	}
+
+	if _, err := (ProfileConfig{AzureTenantID: "unknown", AzureFederationMetadataURL: StringToPointer(idp.URL + "/missing")}).SAMLCertificates(context.Background()); err == nil {
+		t.Error("SAMLCertificates() of a missing federation metadata succeeded, want an error")
+	}
+}
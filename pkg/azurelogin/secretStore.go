@@ -0,0 +1,280 @@
+package azurelogin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gofrs/flock"
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	SECRET_SERVICE        = "go-aws-azure-login"
+	SECRET_PASSPHRASE_ENV = "AZURE_LOGIN_SECRETS_PASSPHRASE"
+
+	AZURE_PASSWORD_SECRET = "azure_password"
+	OKTA_PASSWORD_SECRET  = "okta_password"
+	AZURE_TOTP_SECRET     = "azure_totp_secret"
+	OKTA_TOTP_SECRET      = "okta_totp_secret"
+	CREDENTIALS_SECRET    = "credentials"
+
+	secretKeyIterations = 600000
+)
+
+var errSecretNotFound = fmt.Errorf("secret not found: %w", fs.ErrNotExist)
+
+// SecretStore keeps the secrets of the profiles out of the AWS config files.
+type SecretStore interface {
+	Get(key string) (string, error)
+	Set(key string, value string) error
+	Delete(key string) error
+}
+
+var (
+	openSecretStoreOnce sync.Once
+	defaultSecretsFile  *secretsFile
+)
+
+// OpenSecretStore returns the system keyring (Secret Service, macOS Keychain or Windows Credential Manager)
+// when it is available, and an encrypted file protected by a passphrase otherwise. The passphrase is read
+// from the AZURE_LOGIN_SECRETS_PASSPHRASE environment variable, or asked with the prompter, failing when
+// the prompter is nil.
+func OpenSecretStore(prompter Prompter) SecretStore {
+	openSecretStoreOnce.Do(func() {
+		_, err := keyring.Get(SECRET_SERVICE, "probe")
+		if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			defaultSecretsFile = &secretsFile{path: Paths[SECRETS]}
+		}
+	})
+
+	if defaultSecretsFile == nil {
+		return keyringStore{}
+	}
+
+	return fileStore{secretsFile: defaultSecretsFile, prompter: prompter}
+}
+
+// GetSecretKey returns the key of the secret of the profile in the secret store.
+func GetSecretKey(profileName string, name string) string {
+	return profileName + ":" + name
+}
+
+type keyringStore struct{}
+
+func (keyringStore) Get(key string) (string, error) {
+	value, err := keyring.Get(SECRET_SERVICE, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", errSecretNotFound
+	}
+	return value, err
+}
+
+func (keyringStore) Set(key string, value string) error {
+	return keyring.Set(SECRET_SERVICE, key, value)
+}
+
+func (keyringStore) Delete(key string) error {
+	err := keyring.Delete(SECRET_SERVICE, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+type encryptedSecrets struct {
+	Salt  []byte
+	Nonce []byte
+	Data  []byte
+}
+
+// secretsFile is the file of the secrets, with the passphrase once it is known.
+type secretsFile struct {
+	path       string
+	mu         sync.Mutex
+	passphrase string
+}
+
+// fileStore keeps the secrets in a file encrypted with AES-GCM, using a key derived from a passphrase
+// read from the AZURE_LOGIN_SECRETS_PASSPHRASE environment variable or asked with the prompter.
+type fileStore struct {
+	*secretsFile
+	prompter Prompter
+}
+
+func (s fileStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, _, err := s.load()
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secrets[key]
+	if !ok {
+		return "", errSecretNotFound
+	}
+
+	return value, nil
+}
+
+func (s fileStore) Set(key string, value string) error {
+	return s.update(func(secrets map[string]string) {
+		secrets[key] = value
+	})
+}
+
+func (s fileStore) Delete(key string) error {
+	return s.update(func(secrets map[string]string) {
+		delete(secrets, key)
+	})
+}
+
+func (s fileStore) update(modify func(secrets map[string]string)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	lock := flock.New(s.path + ".lock")
+	if err := lock.Lock(); err != nil {
+		return fmt.Errorf("fail to lock secrets file: %w", err)
+	}
+	defer lock.Unlock()
+
+	secrets, salt, err := s.load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	modify(secrets)
+
+	return s.save(secrets, salt)
+}
+
+func (s fileStore) load() (map[string]string, []byte, error) {
+	secrets := map[string]string{}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return secrets, nil, err
+	}
+
+	var encrypted encryptedSecrets
+	if err := json.Unmarshal(data, &encrypted); err != nil {
+		return secrets, nil, fmt.Errorf("fail to read secrets file: %w", err)
+	}
+
+	gcm, err := s.cipher(encrypted.Salt)
+	if err != nil {
+		return secrets, nil, err
+	}
+
+	plain, err := gcm.Open(nil, encrypted.Nonce, encrypted.Data, nil)
+	if err != nil {
+		return secrets, nil, errors.New("fail to decrypt secrets file: wrong passphrase")
+	}
+
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return secrets, nil, fmt.Errorf("fail to read secrets file: %w", err)
+	}
+
+	return secrets, encrypted.Salt, nil
+}
+
+func (s fileStore) save(secrets map[string]string, salt []byte) error {
+	if salt == nil {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+	}
+
+	gcm, err := s.cipher(salt)
+	if err != nil {
+		return err
+	}
+
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(encryptedSecrets{
+		Salt:  salt,
+		Nonce: nonce,
+		Data:  gcm.Seal(nil, nonce, plain, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+func (s fileStore) cipher(salt []byte) (cipher.AEAD, error) {
+	if s.passphrase == "" {
+		s.passphrase = os.Getenv(SECRET_PASSPHRASE_ENV)
+	}
+
+	if s.passphrase == "" {
+		if s.prompter == nil {
+			return nil, fmt.Errorf("the secrets file passphrase is not set, set %s to use the secret store without prompting", SECRET_PASSPHRASE_ENV)
+		}
+
+		passphrase, err := s.prompter.Password("Secrets file passphrase:")
+		if err != nil {
+			return nil, fmt.Errorf("fail to get secrets file passphrase: %w", err)
+		}
+		s.passphrase = passphrase
+	}
+
+	key, err := pbkdf2.Key(sha256.New, s.passphrase, salt, secretKeyIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// getSecret returns the secret of the profile from the secret store, or the default value when it isn't saved there.
+// It is only used without prompting, so the passphrase of the secrets file is never asked.
+func getSecret(profileName string, name string, defaultValue *string, out io.Writer) *string {
+	value, err := OpenSecretStore(nil).Get(GetSecretKey(profileName, name))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(out, "Fail to read %s from the secret store: %v\n", name, err)
+		}
+		return defaultValue
+	}
+
+	return &value
+}
@@ -0,0 +1,36 @@
+package azurelogin
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStorePassphrase(t *testing.T) {
+	t.Setenv(SECRET_PASSPHRASE_ENV, "")
+
+	path := filepath.Join(t.TempDir(), "secrets")
+
+	store := fileStore{secretsFile: &secretsFile{path: path}}
+
+	if err := store.Set("key", "value"); err == nil || !strings.Contains(err.Error(), SECRET_PASSPHRASE_ENV) {
+		t.Fatalf("Set() without passphrase error = %v, want an error mentioning %s", err, SECRET_PASSPHRASE_ENV)
+	}
+
+	store.prompter = &answersPrompter{t: t, answers: []string{"passphrase"}}
+	if err := store.Set("key", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	// the passphrase is remembered once it was asked
+	store.prompter = nil
+	if value, err := store.Get("key"); err != nil || value != "value" {
+		t.Errorf("Get() = %q, %v, want %q", value, err, "value")
+	}
+
+	t.Setenv(SECRET_PASSPHRASE_ENV, "passphrase")
+	store = fileStore{secretsFile: &secretsFile{path: path}}
+	if value, err := store.Get("key"); err != nil || value != "value" {
+		t.Errorf("Get() with %s = %q, %v, want %q", SECRET_PASSPHRASE_ENV, value, err, "value")
+	}
+}
@@ -0,0 +1,194 @@
+package azurelogin
+
+import (
+	"io"
+	"os"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/go-rod/rod"
+)
+
+// LoginSession is the state of a login shared by the state handlers.
+type LoginSession struct {
+	// Page is the login page
+	Page *rod.Page
+	// ProfileName is the name of the profile logging in
+	ProfileName string
+	// Profile is the configuration of the profile
+	Profile ProfileConfig
+	// NoPrompt accepts the default choices instead of prompting for input
+	NoPrompt bool
+	// Gui lets the user perform the login in the browser
+	Gui bool
+	// FastPass uses Okta FastPass verification
+	FastPass bool
+	// Prompter asks the user for input
+	Prompter Prompter
+	// Secrets provides the passwords and verification codes
+	Secrets SecretSource
+	// Output receives the messages shown during the login
+	Output io.Writer
+
+	// authenticatorResends counts the sign in requests sent again without prompting, to give up when they are never approved
+	authenticatorResends int
+	// submittedPasswords are the secret names whose password was filled in from the secret source
+	submittedPasswords map[string]bool
+	// submittedCodes are the secret names whose verification code was generated and filled in
+	submittedCodes map[string]bool
+}
+
+// CanPrompt tells if the handlers can ask the user for input, instead of using the defaults or
+// letting the user fill the page in the browser.
+func (s *LoginSession) CanPrompt() bool {
+	return !s.NoPrompt && !s.Gui
+}
+
+// storedPassword returns the password of the secret source to fill in, nil when there is none. Once it was
+// rejected, it isn't submitted again so as not to lock the account out: nil is returned to prompt for the
+// password or let the user fill it in the browser, and an error without prompting.
+func (s *LoginSession) storedPassword(name string, rejected bool) (*string, error) {
+	if rejected && s.submittedPasswords[name] {
+		if s.NoPrompt {
+			return nil, &LoginError{
+				Explanation: "The saved password was rejected",
+				Hint:        "Check the password command of the profile, or update the saved password with -configure.",
+				ExitCode:    EXIT_INVALID_CREDENTIALS,
+			}
+		}
+		return nil, nil
+	}
+
+	password := s.Secrets.Password(name)
+	if password != nil {
+		if s.submittedPasswords == nil {
+			s.submittedPasswords = map[string]bool{}
+		}
+		s.submittedPasswords[name] = true
+	}
+
+	return password, nil
+}
+
+// storedVerificationCode returns the verification code generated from the secret source, nil when there is
+// none. Once a generated code was rejected, the next one would be rejected too, an error is returned.
+func (s *LoginSession) storedVerificationCode(name string, rejected bool) (*string, error) {
+	if rejected && s.submittedCodes[name] {
+		return nil, &LoginError{
+			Explanation: "The generated verification code was rejected",
+			Hint:        "Check the TOTP secret of the profile with -configure, and the clock of this computer.",
+			ExitCode:    EXIT_MFA_FAILED,
+		}
+	}
+
+	code := s.Secrets.VerificationCode(name)
+	if code != nil {
+		if s.submittedCodes == nil {
+			s.submittedCodes = map[string]bool{}
+		}
+		s.submittedCodes[name] = true
+	}
+
+	return code, nil
+}
+
+// Prompter asks the user for the values filled in the login pages.
+type Prompter interface {
+	// Input asks for a required value
+	Input(message string, defaultValue string) (string, error)
+	// Password asks for a required value without echoing it
+	Password(message string) (string, error)
+	// Select asks to pick one of the options
+	Select(message string, options []string, defaultValue string) (string, error)
+}
+
+// SurveyPrompter prompts in the terminal, writing the prompts to Output, os.Stdout when nil.
+type SurveyPrompter struct {
+	Output *os.File
+}
+
+func (p SurveyPrompter) Input(message string, defaultValue string) (string, error) {
+	value := ""
+	prompt := &survey.Input{
+		Message: message,
+		Default: defaultValue,
+	}
+	err := p.askOne(prompt, &value)
+	return value, err
+}
+
+func (p SurveyPrompter) Password(message string) (string, error) {
+	value := ""
+	prompt := &survey.Password{
+		Message: message,
+	}
+	err := p.askOne(prompt, &value)
+	return value, err
+}
+
+func (p SurveyPrompter) Select(message string, options []string, defaultValue string) (string, error) {
+	value := ""
+	prompt := &survey.Select{
+		Message: message,
+		Options: options,
+		Default: defaultValue,
+	}
+	err := p.askOne(prompt, &value)
+	return value, err
+}
+
+func (p SurveyPrompter) askOne(prompt survey.Prompt, value *string) error {
+	opts := []survey.AskOpt{survey.WithValidator(survey.Required)}
+	if p.Output != nil {
+		opts = append(opts, survey.WithStdio(os.Stdin, p.Output, os.Stderr))
+	}
+	return survey.AskOne(prompt, value, opts...)
+}
+
+// SecretSource provides the passwords and verification codes filled in the login pages, by secret name
+// (AZURE_PASSWORD_SECRET, OKTA_PASSWORD_SECRET, AZURE_TOTP_SECRET, OKTA_TOTP_SECRET). They are nil when unknown.
+type SecretSource interface {
+	Password(name string) *string
+	VerificationCode(name string) *string
+}
+
+// profileSecrets gets the passwords from the password commands, the secret store or the configuration of
+// the profile, and generates the verification codes from the TOTP secrets of the secret store.
+type profileSecrets struct {
+	profileName string
+	profile     ProfileConfig
+	noPrompt    bool
+	passwords   map[string]func() *string
+	out         io.Writer
+}
+
+func newProfileSecrets(profileName string, profile ProfileConfig, noPrompt bool, out io.Writer) *profileSecrets {
+	if noPrompt && profile.AzureUseSecretStore {
+		profile.AzureDefaultPassword = getSecret(profileName, AZURE_PASSWORD_SECRET, profile.AzureDefaultPassword, out)
+		profile.OktaDefaultPassword = getSecret(profileName, OKTA_PASSWORD_SECRET, profile.OktaDefaultPassword, out)
+	}
+
+	return &profileSecrets{
+		profileName: profileName,
+		profile:     profile,
+		noPrompt:    noPrompt,
+		passwords: map[string]func() *string{
+			AZURE_PASSWORD_SECRET: getPasswordSource(profile.AzurePasswordCommand, profile.AzureDefaultPassword, noPrompt, out),
+			OKTA_PASSWORD_SECRET:  getPasswordSource(profile.OktaPasswordCommand, profile.OktaDefaultPassword, noPrompt, out),
+		},
+		out: out,
+	}
+}
+
+func (s *profileSecrets) Password(name string) *string {
+	if getPassword, ok := s.passwords[name]; ok {
+		return getPassword()
+	}
+	return nil
+}
+
+func (s *profileSecrets) VerificationCode(name string) *string {
+	if !s.noPrompt || !s.profile.AzureUseSecretStore {
+		return nil
+	}
+	return getTotpCode(s.profileName, name, s.out)
+}
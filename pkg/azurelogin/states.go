@@ -0,0 +1,827 @@
+package azurelogin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+)
+
+const authenticatorApprovalTimeout = 90 * time.Second
+
+// State is a login page, recognized by the CSS selector, and its handler.
+type State struct {
+	Name     string
+	Selector string
+	Handler  StateHandler
+}
+
+// StateHandler handles a login page, el being the element matched by the selector of its state.
+type StateHandler interface {
+	Handle(s *LoginSession, el *rod.Element) error
+}
+
+// StateHandlerFunc is a function handling a login page.
+type StateHandlerFunc func(s *LoginSession, el *rod.Element) error
+
+func (f StateHandlerFunc) Handle(s *LoginSession, el *rod.Element) error {
+	return f(s, el)
+}
+
+var (
+	registeredStatesMu sync.RWMutex
+	registeredStates   []State
+)
+
+// RegisterState adds a login page to the built-in ones, e.g. a company specific interstitial page
+// like terms of use or a device compliance notice. The registered pages are checked before the built-in
+// ones, in the order they were registered, so a registered page can also take over a built-in one.
+func RegisterState(st State) {
+	registeredStatesMu.Lock()
+	defer registeredStatesMu.Unlock()
+
+	registeredStates = append(registeredStates, st)
+}
+
+// getStates returns the registered states, then the built-in ones.
+func getStates() []State {
+	registeredStatesMu.RLock()
+	defer registeredStatesMu.RUnlock()
+
+	return append(append([]State{}, registeredStates...), states...)
+}
+
+var states = []State{
+	{
+		Name:     "username input",
+		Selector: `input[name="loginfmt"]:not(.moveOffScreen)`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			username := s.Profile.AzureDefaultUsername
+
+			if s.CanPrompt() {
+				var err error
+				if username, err = s.Prompter.Input("Azure Username:", s.Profile.AzureDefaultUsername); err != nil {
+					return err
+				}
+			}
+
+			if len(username) > 0 {
+				el.MustWaitVisible()
+				el.MustSelectAllText().MustInput("")
+				el.MustInput(strings.TrimSpace(username))
+
+				sb := pg.MustElement(`input[type=submit]`)
+
+				sb.MustWaitVisible()
+				wait := pg.MustWaitRequestIdle()
+				sb.MustClick()
+				wait()
+
+				pContext := pg.GetContext()
+				defer func() {
+					pg.Context(pContext)
+				}()
+
+				ctx, cancel := context.WithCancel(pContext)
+				defer cancel()
+
+				ch := make(chan bool, 1)
+
+				go func() {
+					for {
+						select {
+						case <-ctx.Done():
+							return
+						default:
+							_, err := pg.Sleeper(rod.NotFoundSleeper).Element("input[name=loginfmt]")
+							if err != nil {
+								ch <- true
+								return
+							}
+						}
+					}
+				}()
+
+				go func() {
+					pg.Timeout(20 * time.Second).Race().
+						Element("input[name=loginfmt].has-error").
+						Element("input[name=loginfmt].moveOffScreen").
+						Element("input[name=loginfmt]").Handle(func(e *rod.Element) error {
+						return e.WaitInvisible()
+					}).Do()
+
+					select {
+					case <-ctx.Done():
+						return
+					default:
+						ch <- true
+						return
+					}
+				}()
+
+				select {
+				case <-ch:
+				case <-time.After(25 * time.Second):
+				}
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     "password input",
+		Selector: `input[name="Password"]:not(.moveOffScreen),input[name="passwd"]:not(.moveOffScreen)`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			alert := getElementText(pg, `.alert-error,#passwordError`)
+
+			if alert != "" {
+				fmt.Fprintln(s.Output, alert)
+			}
+
+			var password string = ""
+
+			defaultUserPassword, err := s.storedPassword(AZURE_PASSWORD_SECRET, alert != "")
+			if err != nil {
+				return err
+			}
+
+			if defaultUserPassword != nil {
+				password = *defaultUserPassword
+			} else if !s.Gui {
+				if password, err = s.Prompter.Password("Azure Password"); err != nil {
+					return err
+				}
+			}
+
+			if len(password) > 0 {
+				el.MustWaitVisible()
+				el.MustSelectAllText().MustInput("")
+				el.MustInput(password)
+
+				wait := pg.MustWaitRequestIdle()
+				pg.MustElement("span[class=submit],input[type=submit]").MustClick()
+				wait()
+
+				time.Sleep(time.Millisecond * 500)
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     "verification code input",
+		Selector: `input[name="otc"]:not(.moveOffScreen)`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			alert, err := pg.Sleeper(rod.NotFoundSleeper).Element("#idSpan_SAOTCC_Error_OTC")
+
+			rejected := false
+			if alert != nil && err == nil {
+				t, _ := alert.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+					rejected = true
+				}
+			}
+
+			var code string = ""
+
+			verificationCode, err := s.storedVerificationCode(AZURE_TOTP_SECRET, rejected)
+			if err != nil {
+				return err
+			}
+
+			if verificationCode != nil {
+				code = *verificationCode
+			} else if !s.Gui {
+				if code, err = s.Prompter.Input("Verification Code:", ""); err != nil {
+					return err
+				}
+			}
+
+			if len(code) > 0 {
+				el.MustWaitVisible()
+				el.MustSelectAllText().MustInput("")
+				el.MustInput(strings.TrimSpace(code))
+
+				wait := pg.MustWaitRequestIdle()
+				pg.MustElement("input[type=submit]").MustClick()
+				wait()
+
+				time.Sleep(time.Millisecond * 500)
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     "authenticator approval",
+		Selector: `#idDiv_SAOTCAS_Description`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			number := ""
+			displaySign, err := pg.Sleeper(rod.NotFoundSleeper).Element("#idRichContext_DisplaySign")
+
+			if displaySign != nil && err == nil {
+				t, _ := displaySign.Text()
+				number = strings.TrimSpace(t)
+			}
+
+			if number != "" {
+				fmt.Fprintf(s.Output, "Open your Authenticator app and enter the number %s to approve the sign in request\n", number)
+			} else {
+				fmt.Fprintln(s.Output, "Approve the sign in request in your Authenticator app")
+			}
+
+			deadline := time.Now().Add(authenticatorApprovalTimeout)
+			lastPrint := time.Now()
+
+			for time.Now().Before(deadline) {
+				visible, err := el.Visible()
+				if err != nil || !visible {
+					return nil
+				}
+
+				if time.Since(lastPrint) >= 15*time.Second {
+					fmt.Fprintf(s.Output, "Waiting for approval (%s left)\n", time.Until(deadline).Round(time.Second))
+					lastPrint = time.Now()
+				}
+
+				time.Sleep(time.Second)
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     "authenticator approval not received",
+		Selector: `#idA_SAASTO_Resend,#idA_SAASDS_Resend`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			title, err := pg.Sleeper(rod.NotFoundSleeper).Element("#idDiv_SAASTO_Title,#idDiv_SAASDS_Title")
+
+			if title != nil && err == nil {
+				t, _ := title.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+				}
+			}
+
+			const (
+				resend      = "Send another request"
+				anotherWay  = "Use another verification method"
+				resendLimit = 3
+			)
+
+			if s.NoPrompt {
+				s.authenticatorResends++
+				if s.authenticatorResends > resendLimit {
+					return &LoginError{Explanation: "The sign in request was not approved", Hint: "Run the login without -no-prompt to use another verification method.", ExitCode: EXIT_MFA_FAILED}
+				}
+			}
+
+			answer := resend
+
+			if s.CanPrompt() {
+				options := []string{resend}
+
+				if _, err := pg.Sleeper(rod.NotFoundSleeper).Element("#signInAnotherWay"); err == nil {
+					options = append(options, anotherWay)
+				}
+
+				if answer, err = s.Prompter.Select("Sign in request not approved:", options, resend); err != nil {
+					return err
+				}
+			}
+
+			btn := el
+			if answer == anotherWay {
+				btn = pg.MustElement("#signInAnotherWay")
+			}
+
+			btn.MustWaitVisible()
+			wait := pg.MustWaitRequestIdle()
+			btn.MustClick()
+			wait()
+
+			time.Sleep(time.Millisecond * 500)
+
+			return nil
+		}),
+	},
+	{
+		Name:     "verification method selection",
+		Selector: `#idDiv_SAOTCS_Proofs [data-value]`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			proofs, err := pg.Elements(`#idDiv_SAOTCS_Proofs [data-value]`)
+			if err != nil || len(proofs) == 0 {
+				return nil
+			}
+
+			proof := proofs[0]
+
+			if s.CanPrompt() {
+				var options []string
+
+				for _, p := range proofs {
+					t, _ := p.Text()
+					options = append(options, strings.TrimSpace(t))
+				}
+
+				answer, err := s.Prompter.Select("Verification method:", options, options[0])
+				if err != nil {
+					return err
+				}
+
+				for i, option := range options {
+					if option == answer {
+						proof = proofs[i]
+						break
+					}
+				}
+			}
+
+			proof.MustWaitVisible()
+			wait := pg.MustWaitRequestIdle()
+			proof.MustClick()
+			wait()
+
+			time.Sleep(time.Millisecond * 500)
+
+			return nil
+		}),
+	},
+	{
+		Name:     "account picker",
+		Selector: `#tilesHolder .table[data-test-id]`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			tiles, err := pg.Elements(`#tilesHolder .table[data-test-id]`)
+			if err != nil || len(tiles) == 0 {
+				return nil
+			}
+
+			const anotherAccount = "Use another account"
+
+			var tile *rod.Element
+			var options []string
+			var optionTiles []*rod.Element
+
+			for _, t := range tiles {
+				username, _ := t.Attribute("data-test-id")
+				if username == nil {
+					continue
+				}
+
+				if defaultUserName := s.Profile.AzureDefaultUsername; defaultUserName != "" && strings.EqualFold(*username, strings.TrimSpace(defaultUserName)) {
+					tile = t
+					break
+				}
+
+				options = append(options, *username)
+				optionTiles = append(optionTiles, t)
+			}
+
+			if tile == nil && !s.Gui {
+				answer := anotherAccount
+
+				if !s.NoPrompt {
+					if answer, err = s.Prompter.Select("Pick an account:", append(options, anotherAccount), anotherAccount); err != nil {
+						return err
+					}
+				}
+
+				// the tile is picked by its position, the username isn't safe to put in a selector
+				if i := slices.Index(options, answer); i >= 0 {
+					tile = optionTiles[i]
+				} else {
+					tile, _ = pg.Sleeper(rod.NotFoundSleeper).Element("#otherTile")
+				}
+			}
+
+			if tile != nil {
+				tile.MustWaitVisible()
+				wait := pg.MustWaitRequestIdle()
+				tile.MustClick()
+				wait()
+
+				time.Sleep(time.Millisecond * 500)
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     "stay signed in",
+		Selector: `input[name="DontShowAgain"]`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			btnSelector := "#idBtn_Back"
+			if s.Profile.AzureDefaultRememberMe {
+				btnSelector = "#idSIButton9"
+			}
+
+			btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(btnSelector)
+			if err == nil && btn != nil {
+				btn.MustWaitVisible()
+				wait := pg.MustWaitRequestIdle()
+				btn.MustClick()
+				wait()
+
+				time.Sleep(time.Millisecond * 500)
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     "OKTA username input",
+		Selector: `form:not(.o-form-saving) > div span.okta-form-input-field input[name="identifier"]:not([disabled])`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			errorSelector := `div.o-form-error-container`
+			errorContainer, err := pg.Sleeper(rod.NotFoundSleeper).Element(errorSelector)
+
+			if errorContainer != nil && err == nil {
+				t, _ := errorContainer.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+				}
+			}
+
+			infoSelector := `div.o-form-info-container`
+			infoContainer, err := pg.Sleeper(rod.NotFoundSleeper).Element(infoSelector)
+			if infoContainer != nil && err == nil {
+				t, _ := infoContainer.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+				}
+			}
+
+			var username string = ""
+
+			defUser := s.Profile.AzureDefaultUsername
+			if s.Profile.OktaDefaultUsername != nil {
+				defUser = *s.Profile.OktaDefaultUsername
+			}
+
+			if s.NoPrompt {
+				username = defUser
+			} else if !s.Gui {
+				if username, err = s.Prompter.Input("Okta Username:", defUser); err != nil {
+					return err
+				}
+			}
+
+			if len(username) > 0 {
+
+				el.MustWaitVisible()
+				el.MustSelectAllText().MustInput("")
+				el.MustInput(username)
+
+				inputSelector := `form:not(.o-form-saving) > div span.okta-form-input-field input[name="identifier"]:not([disabled])`
+
+				btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`input:not([disabled]):not(.link-button-disabled):not(.btn-disabled)[type=submit]`)
+				if err == nil {
+					wait := pg.MustWaitRequestIdle()
+					btn.MustClick()
+					wait()
+
+					pContext := pg.GetContext()
+					defer func() {
+						pg.Context(pContext)
+					}()
+
+					ctx, cancel := context.WithCancel(pContext)
+					defer cancel()
+
+					ch := make(chan bool, 1)
+
+					go func() {
+						for {
+							select {
+							case <-ctx.Done():
+								return
+							default:
+								_, err := pg.Sleeper(rod.NotFoundSleeper).Element(inputSelector)
+								if err != nil {
+									ch <- true
+									return
+								}
+							}
+						}
+					}()
+
+					go func() {
+						pg.Timeout(20 * time.Second).Race().
+							Element(errorSelector + `.o-form-has-errors`).Handle(func(e *rod.Element) error {
+							if e != nil {
+								t, _ := e.Text()
+								if t != "" {
+									return errors.New("error returned")
+								}
+							}
+							return nil
+						}).
+							Element(inputSelector).Handle(func(e *rod.Element) error {
+							return e.WaitInvisible()
+						}).Do()
+
+						select {
+						case <-ctx.Done():
+							return
+						default:
+							ch <- true
+							return
+						}
+					}()
+
+					select {
+					case <-ch:
+					case <-time.After(25 * time.Second):
+					}
+				}
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     "OKTA password input",
+		Selector: `form:not(.o-form-saving) > div span.okta-form-input-field input[type="password"]:not([disabled])`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			errorSelector := `div.o-form-error-container`
+			errorContainer, err := pg.Sleeper(rod.NotFoundSleeper).Element(errorSelector)
+			rejected := false
+
+			if errorContainer != nil && err == nil {
+				t, _ := errorContainer.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+					rejected = true
+				}
+			}
+
+			infoSelector := `div.o-form-info-container`
+			infoContainer, err := pg.Sleeper(rod.NotFoundSleeper).Element(infoSelector)
+			if infoContainer != nil && err == nil {
+				t, _ := infoContainer.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+				}
+			}
+
+			var password string = ""
+			shouldAskPassword := true
+
+			defaultOktaPassword, err := s.storedPassword(OKTA_PASSWORD_SECRET, rejected)
+			if err != nil {
+				return err
+			}
+
+			if defaultOktaPassword != nil {
+				password = *defaultOktaPassword
+				shouldAskPassword = false
+			} else if s.NoPrompt {
+				defaultUserPassword, err := s.storedPassword(AZURE_PASSWORD_SECRET, rejected)
+				if err != nil {
+					return err
+				}
+
+				if defaultUserPassword != nil {
+					password = *defaultUserPassword
+					shouldAskPassword = false
+				}
+			}
+
+			if shouldAskPassword && !s.Gui {
+				if password, err = s.Prompter.Password("Okta Password:"); err != nil {
+					return err
+				}
+			}
+
+			if len(password) > 0 {
+
+				time.Sleep(time.Millisecond * 500)
+
+				el.MustWaitVisible()
+				el.MustSelectAllText().MustInput("")
+				el.MustInput(password)
+
+				inputSelector := `form:not(.o-form-saving) > div span.okta-form-input-field input[type="password"]:not([disabled])`
+
+				btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`input:not([disabled]):not(.link-button-disabled):not(.btn-disabled)[type=submit]`)
+				if err == nil {
+					wait := pg.MustWaitRequestIdle()
+					btn.MustClick()
+					wait()
+
+					pContext := pg.GetContext()
+					defer func() {
+						pg.Context(pContext)
+					}()
+
+					ctx, cancel := context.WithCancel(pContext)
+					defer cancel()
+
+					ch := make(chan bool, 1)
+
+					go func() {
+						for {
+							select {
+							case <-ctx.Done():
+								return
+							default:
+								_, err := pg.Sleeper(rod.NotFoundSleeper).Element(inputSelector)
+								if err != nil {
+									ch <- true
+									return
+								}
+							}
+						}
+					}()
+
+					go func() {
+						pg.Timeout(20 * time.Second).Race().
+							Element(errorSelector + `.o-form-has-errors`).Handle(func(e *rod.Element) error {
+							if e != nil {
+								t, _ := e.Text()
+								if t != "" {
+									return errors.New("error returned")
+								}
+							}
+							return nil
+						}).
+							Element(inputSelector).Handle(func(e *rod.Element) error {
+							return e.WaitInvisible()
+						}).Do()
+
+						select {
+						case <-ctx.Done():
+							return
+						default:
+							ch <- true
+							return
+						}
+					}()
+
+					select {
+					case <-ch:
+					case <-time.After(25 * time.Second):
+					}
+				}
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     "OKTA verification code input",
+		Selector: `form:not(.o-form-saving) > div span.okta-form-input-field input[name="credentials.passcode"]:not([type="password"]):not([disabled]),form:not(.o-form-saving) > div span.okta-form-input-field input[name="answer"]:not([disabled])`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			errorContainer, err := pg.Sleeper(rod.NotFoundSleeper).Element(`div.o-form-error-container`)
+
+			rejected := false
+			if errorContainer != nil && err == nil {
+				t, _ := errorContainer.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+					rejected = true
+				}
+			}
+
+			var code string = ""
+
+			verificationCode, err := s.storedVerificationCode(OKTA_TOTP_SECRET, rejected)
+			if err != nil {
+				return err
+			}
+
+			if verificationCode != nil {
+				code = *verificationCode
+			} else if !s.Gui {
+				if code, err = s.Prompter.Input("Okta Verification Code:", ""); err != nil {
+					return err
+				}
+			}
+
+			if len(code) > 0 {
+				el.MustWaitVisible()
+				el.MustSelectAllText().MustInput("")
+				el.MustInput(strings.TrimSpace(code))
+
+				btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`input:not([disabled]):not(.link-button-disabled):not(.btn-disabled)[type=submit]`)
+				if err == nil {
+					wait := pg.MustWaitRequestIdle()
+					btn.MustClick()
+					wait()
+					time.Sleep(time.Millisecond * 500)
+				}
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     OKTA_SELECT_FAST_PASS,
+		Selector: `div[data-se="okta_verify-signed_nonce"] > a:not([disabled]):not(.link-button-disabled):not(.btn-disabled)`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			alert, err := pg.Sleeper(rod.NotFoundSleeper).Element(".infobox-error")
+
+			if alert != nil && err == nil {
+				t, _ := alert.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+				}
+			}
+
+			btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`div[data-se="okta_verify-signed_nonce"] > a:not([disabled]):not(.btn-disabled):not(.link-button-disabled)`)
+			if err == nil && btn != nil {
+				btn.MustWaitVisible()
+				wait := pg.MustWaitRequestIdle()
+				btn.MustClick()
+				wait()
+				time.Sleep(time.Millisecond * 500)
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     OKTA_SELECT_PUSH_FORM,
+		Selector: `div[data-se="okta_verify-push"] > a:not([disabled]):not(.link-button-disabled):not(.btn-disabled)`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			alert, err := pg.Sleeper(rod.NotFoundSleeper).Element(".infobox-error")
+
+			if alert != nil && err == nil {
+				t, _ := alert.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+				}
+			}
+
+			btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`div[data-se="okta_verify-push"] > a:not([disabled]):not(.btn-disabled):not(.link-button-disabled)`)
+			if err == nil && btn != nil {
+				btn.MustWaitVisible()
+				wait := pg.MustWaitRequestIdle()
+				btn.MustClick()
+				wait()
+				time.Sleep(time.Millisecond * 500)
+			}
+
+			return nil
+		}),
+	},
+	{
+		Name:     OKTA_DO_PUSH_FORM,
+		Selector: `a.send-push:not([disabled]):not(.link-button-disabled):not(.btn-disabled)`,
+		Handler: StateHandlerFunc(func(s *LoginSession, el *rod.Element) error {
+			pg := s.Page
+
+			alert, err := pg.Sleeper(rod.NotFoundSleeper).Element(".infobox-error")
+
+			if alert != nil && err == nil {
+				t, _ := alert.Text()
+				if t != "" {
+					fmt.Fprintln(s.Output, t)
+				}
+			}
+
+			btn, err := pg.Sleeper(rod.NotFoundSleeper).Element(`a.send-push:not([disabled]):not(.btn-disabled):not(.link-button-disabled)`)
+			if err == nil && btn != nil {
+				btn.MustWaitVisible()
+				wait := pg.MustWaitRequestIdle()
+				btn.MustClick()
+				wait()
+				time.Sleep(time.Millisecond * 500)
+			}
+
+			return nil
+		}),
+	},
+}
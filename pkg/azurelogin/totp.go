@@ -0,0 +1,60 @@
+package azurelogin
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+)
+
+// getTotpCode generates the current verification code from the TOTP secret of the profile kept in the secret store.
+func getTotpCode(profileName string, secretName string, out io.Writer) *string {
+	secret := getSecret(profileName, secretName, nil, out)
+	if secret == nil {
+		return nil
+	}
+
+	code, err := generateTotpCode(*secret, time.Now())
+	if err != nil {
+		fmt.Fprintf(out, "Fail to generate verification code: %v\n", err)
+		return nil
+	}
+
+	return &code
+}
+
+// generateTotpCode generates a RFC 6238 code (HMAC-SHA1, 30 seconds period, 6 digits) from a base32 encoded secret.
+func generateTotpCode(secret string, t time.Time) (string, error) {
+	secret = strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "="))
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/totpPeriod))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for range totpDigits {
+		modulus *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%modulus), nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/luneo7/go-aws-azure-login/pkg/azurelogin"
+)
+
+const serveRefreshInterval = time.Minute
+
+type containerCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+type credentialsServer struct {
+	mu          sync.RWMutex
+	token       string
+	credentials azurelogin.Credentials
+}
+
+// errRefreshPrompt is returned by the prompts of the background refreshes, nobody answers them.
+var errRefreshPrompt = errors.New("cannot prompt while refreshing the credentials in the background")
+
+// refreshPrompter fails every prompt of the background refreshes.
+type refreshPrompter struct{}
+
+func (refreshPrompter) Input(message string, defaultValue string) (string, error) {
+	return "", errRefreshPrompt
+}
+
+func (refreshPrompter) Password(message string) (string, error) {
+	return "", errRefreshPrompt
+}
+
+func (refreshPrompter) Select(message string, options []string, defaultValue string) (string, error) {
+	return "", errRefreshPrompt
+}
+
+// serveCredentials exposes the credentials of the profile through an HTTP endpoint compatible with
+// AWS_CONTAINER_CREDENTIALS_FULL_URI. The credentials are refreshed in the background when they are about to expire,
+// without prompting and with the role and duration chosen at the first login. A failed refresh is logged and retried
+// on the next tick while the current credentials are served until they expire. Each login is limited by loginTimeout,
+// and the server stops when the context is done.
+func serveCredentials(
+	ctx context.Context,
+	profileName string,
+	listenAddress string,
+	loginTimeout time.Duration,
+	forceRefresh bool,
+	awsNoVerifySsl bool,
+	noPrompt bool,
+	isGui bool,
+	disableLeakless bool,
+	fastPass bool,
+	diagnosticsDir string) {
+
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if token == "" {
+		token = generateToken()
+	}
+
+	opts := newLoginOptions(profileName, loadProfile(profileName), awsNoVerifySsl, noPrompt, isGui, disableLeakless, fastPass, diagnosticsDir, loginTimeout)
+
+	credentials, ok := loadCachedCredentials(profileName, noPrompt)
+	if !ok || forceRefresh {
+		credentials = loginAndRememberRole(ctx, &opts)
+		saveCachedCredentials(profileName, credentials, noPrompt)
+	}
+
+	server := &credentialsServer{
+		token:       token,
+		credentials: credentials,
+	}
+
+	listener, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to listen on %s: %v", listenAddress, err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stdout, "export AWS_CONTAINER_CREDENTIALS_FULL_URI='http://%s/'\n", listener.Addr().String())
+	fmt.Fprintf(os.Stdout, "export AWS_CONTAINER_AUTHORIZATION_TOKEN='%s'\n", token)
+
+	// nobody answers the prompts in the background
+	opts.NoPrompt = true
+	opts.Prompter = refreshPrompter{}
+
+	ticker := time.NewTicker(serveRefreshInterval)
+	defer ticker.Stop()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if !server.isAboutToExpire() {
+				continue
+			}
+
+			fmt.Fprintln(messages, "Refreshing credentials")
+
+			credentials, err := azurelogin.Login(ctx, opts)
+			if err != nil {
+				fmt.Fprintf(messages, "Fail to refresh credentials, retrying in %s: %v\n", serveRefreshInterval, err)
+				continue
+			}
+
+			saveCachedCredentials(profileName, *credentials, true)
+			server.setCredentials(*credentials)
+		}
+	}()
+
+	httpServer := &http.Server{Handler: server}
+
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(messages, "Fail to serve credentials: %v", err)
+		os.Exit(1)
+	}
+}
+
+func (s *credentialsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(s.token)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	credentials := s.getCredentials()
+
+	if s.isExpired() {
+		http.Error(w, "The credentials expired and could not be refreshed", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(containerCredentials{
+		AccessKeyId:     credentials.AwsAccessKeyID,
+		SecretAccessKey: credentials.AwsSecretAccessKey,
+		Token:           credentials.AwsSessionToken,
+		Expiration:      credentials.AwsExpiration,
+	})
+}
+
+// getCredentials returns the current credentials, the last ones while they are refreshed.
+func (s *credentialsServer) getCredentials() azurelogin.Credentials {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.credentials
+}
+
+func (s *credentialsServer) setCredentials(credentials azurelogin.Credentials) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.credentials = credentials
+}
+
+// isAboutToExpire tells if the current credentials should be refreshed.
+func (s *credentialsServer) isAboutToExpire() bool {
+	expirationDate, err := time.Parse(azurelogin.TimeFormat, s.getCredentials().AwsExpiration)
+
+	return err != nil || azurelogin.IsAboutToExpire(expirationDate)
+}
+
+// isExpired tells if the current credentials can no longer be used.
+func (s *credentialsServer) isExpired() bool {
+	expirationDate, err := time.Parse(azurelogin.TimeFormat, s.getCredentials().AwsExpiration)
+
+	return err != nil || !time.Now().Before(expirationDate)
+}
+
+// loginAndRememberRole logs in and stores the chosen role and duration as the defaults of the profile
+// in the options, so that the next logins with them do not ask again.
+func loginAndRememberRole(ctx context.Context, opts *azurelogin.Options) azurelogin.Credentials {
+	saml, response, err := azurelogin.GetSAMLResponse(ctx, *opts)
+	if err != nil {
+		exitWithLoginError(err)
+	}
+
+	roles, err := response.Roles()
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to parse roles: %v", err)
+		os.Exit(1)
+	}
+
+	session, err := response.SessionAttributes()
+	if err != nil {
+		fmt.Fprintf(messages, "Fail to parse session attributes: %v", err)
+		os.Exit(1)
+	}
+
+	rl, durationHours, err := azurelogin.AskUserForRoleAndDuration(roles, session, *opts)
+	if err != nil {
+		exitWithLoginError(err)
+	}
+
+	credentials, err := azurelogin.AssumeRole(ctx, saml, rl, durationHours, *opts)
+	if err != nil {
+		exitWithLoginError(err)
+	}
+
+	opts.Profile.AzureDefaultRoleArn = rl.RoleArn
+	opts.Profile.AzureDefaultDurationHours = strconv.Itoa(int(durationHours))
+
+	return *credentials
+}
+
+func generateToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		fmt.Fprintf(messages, "Fail to generate authorization token: %v", err)
+		os.Exit(1)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}